@@ -4,8 +4,9 @@
 <head>
   <meta charset="UTF-8">
   <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <base href="/">
   <title>DayZ Web Maps</title>
-  <link rel="icon" type="image/x-icon" href="/favicon.ico">
+  <link rel="icon" type="image/x-icon" href="favicon.ico">
   <link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css" />
   <style>{{ .CSS }}</style>
 </head>
@@ -26,8 +27,8 @@
     <div class="divider"></div>
 
     <div class="layer-switch">
-      <button class="layer-btn active" onclick="setLayerType('topographic')" id="btn-top">Topo</button>
-      <button class="layer-btn" onclick="setLayerType('satellite')" id="btn-sat">Sat</button>
+      <div class="layer-group" id="baseLayers"></div>
+      <div class="layer-group overlay-group" id="overlayLayers"></div>
       <div style="width:1px; background:var(--border); margin: 0 4px;"></div>
       <button class="layer-btn active" onclick="toggleLocations()" id="btn-loc">Loc</button>
     </div>
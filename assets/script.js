@@ -6,7 +6,16 @@ document.addEventListener('DOMContentLoaded', () => {
     extraZoom: 3,
     baseBounds: [[0, 0], [-256, 256]],
     maxBounds: [[100, -100], [-356, 356]],
-    errorTile: 'data:image/webp;base64,UklGRkIAAABXRUJQVlA4WAoAAAAQAAAAAAAAAAAAQUxQSAgAAAAAAFYAMQAAQUYDNQAA'
+    errorTile: 'data:image/webp;base64,UklGRkIAAABXRUJQVlA4WAoAAAAQAAAAAAAAAAAAQUxQSAgAAAAAAFYAMQAAQUYDNQAA',
+    // path the server is mounted at, injected as <base href> (always ends with '/')
+    basePath: new URL(document.baseURI).pathname,
+    // API key or signed URL of private maps, passed on from the page URL
+    authQuery: (() => {
+      const page = new URLSearchParams(window.location.search);
+      const auth = new URLSearchParams();
+      ['api_key', 'expires', 'signature'].forEach(k => { if (page.has(k)) auth.set(k, page.get(k)); });
+      return auth.toString() ? `?${auth}` : '';
+    })()
   };
 
   const DOM = {
@@ -18,8 +27,8 @@ document.addEventListener('DOMContentLoaded', () => {
     selectOptions: document.getElementById('selectOptions'),
 
     steamLink: document.getElementById('steamLink'),
-    btnTop: document.getElementById('btn-top'),
-    btnSat: document.getElementById('btn-sat'),
+    baseLayers: document.getElementById('baseLayers'),
+    overlayLayers: document.getElementById('overlayLayers'),
     btnLoc: document.getElementById('btn-loc'),
     valGame: document.getElementById('val-game'),
     valWorld: document.getElementById('val-world')
@@ -31,8 +40,10 @@ document.addEventListener('DOMContentLoaded', () => {
     currentMap: null,
     selectedMapName: null,
     layerType: 'topographic',
+    overlays: new Set(),
     showLocations: true,
     tileLayer: null,
+    overlayTiles: {},
     locationsLayer: L.layerGroup()
   };
 
@@ -124,25 +135,18 @@ document.addEventListener('DOMContentLoaded', () => {
     updateUI(config);
     updateURL(mapName, state.layerType, updateHistory);
 
-    // 4. Load Tile Layer
-    const actualLimit = config.zoom || 8;
+    // 4. Load Tile Layers
+    const layer = findLayer(config, state.layerType) || {};
+    const actualLimit = layer.zoom || config.zoom || 8;
     const displayLimit = actualLimit + CONFIG.extraZoom;
     map.setMaxZoom(displayLimit);
 
     if (state.tileLayer) map.removeLayer(state.tileLayer);
+    Object.values(state.overlayTiles).forEach(t => map.removeLayer(t));
+    state.overlayTiles = {};
 
-    const url = `/maps/${mapName}/${state.layerType}/{z}/{x}/{y}.webp`;
-    state.tileLayer = L.tileLayer(url, {
-      tileSize: CONFIG.tileSize,
-      noWrap: true,
-      tms: false,
-      minZoom: CONFIG.minZoom,
-      maxNativeZoom: actualLimit,
-      maxZoom: displayLimit,
-      bounds: CONFIG.baseBounds,
-      attribution: config.attribution,
-      errorTileUrl: CONFIG.errorTile
-    }).addTo(map);
+    state.tileLayer = createTileLayer(mapName, config, layer).addTo(map);
+    state.overlays.forEach(name => addOverlay(mapName, config, name));
 
     if (!state.tileLayer._url_changed_only) {
       map.fitBounds(CONFIG.baseBounds);
@@ -153,21 +157,70 @@ document.addEventListener('DOMContentLoaded', () => {
     handleLocations(mapName, config);
   }
 
-  function validateLayerAvailability(config) {
-    const hasTopo = !config.no_topographic;
-    const hasSat = !config.no_satellite;
+  function createTileLayer(mapName, config, layer) {
+    const nativeZoom = layer.zoom || config.zoom || 8;
+    const url = `${mapPath(mapName, config)}/${layer.name}/{z}/{x}/{y}.webp${CONFIG.authQuery}`;
+    return L.tileLayer(url, {
+      tileSize: CONFIG.tileSize,
+      noWrap: true,
+      tms: false,
+      minZoom: CONFIG.minZoom,
+      maxNativeZoom: nativeZoom,
+      maxZoom: nativeZoom + CONFIG.extraZoom,
+      bounds: CONFIG.baseBounds,
+      attribution: layer.attribution || config.attribution,
+      errorTileUrl: layer.overlay ? '' : CONFIG.errorTile,
+      zIndex: layer.overlay ? 2 : 1
+    });
+  }
+
+  // Versioned map URLs are cached by the browser without revalidation
+  function mapPath(mapName, config) {
+    const version = config.version ? `@${config.version}` : '';
+    return `${CONFIG.basePath}maps/${mapName}${version}`;
+  }
 
-    DOM.btnTop.classList.toggle('disabled', !hasTopo);
-    DOM.btnSat.classList.toggle('disabled', !hasSat);
+  function addOverlay(mapName, config, name) {
+    const layer = findLayer(config, name);
+    if (!layer || state.overlayTiles[name]) return;
+    state.overlayTiles[name] = createTileLayer(mapName, config, layer).addTo(map);
+  }
+
+  function findLayer(config, name) {
+    return (config.layers || []).find(l => l.name === name);
+  }
+
+  function layerLabel(name) {
+    if (name === 'topographic') return 'Topo';
+    if (name === 'satellite') return 'Sat';
+    return name.charAt(0).toUpperCase() + name.slice(1).replace(/[-_]/g, ' ');
+  }
+
+  function layerButton(layer, active, onClick) {
+    const btn = document.createElement('button');
+    btn.className = 'layer-btn' + (active ? ' active' : '');
+    btn.textContent = layerLabel(layer.name);
+    btn.title = layer.name;
+    btn.onclick = onClick;
+    return btn;
+  }
 
-    if (state.layerType === 'topographic' && !hasTopo && hasSat) {
-      state.layerType = 'satellite';
-    } else if (state.layerType === 'satellite' && !hasSat && hasTopo) {
-      state.layerType = 'topographic';
+  function validateLayerAvailability(config) {
+    const layers = config.layers || [];
+    const base = layers.filter(l => !l.overlay);
+    const overlays = layers.filter(l => l.overlay);
+
+    if (!base.some(l => l.name === state.layerType) && base.length > 0) {
+      state.layerType = base[0].name;
     }
+    state.overlays.forEach(name => {
+      if (!overlays.some(l => l.name === name)) state.overlays.delete(name);
+    });
 
-    DOM.btnTop.classList.toggle('active', state.layerType === 'topographic');
-    DOM.btnSat.classList.toggle('active', state.layerType === 'satellite');
+    DOM.baseLayers.replaceChildren(...base.map(l =>
+      layerButton(l, l.name === state.layerType, () => window.setLayerType(l.name))));
+    DOM.overlayLayers.replaceChildren(...overlays.map(l =>
+      layerButton(l, state.overlays.has(l.name), () => window.toggleOverlay(l.name))));
   }
 
   function handleLocations(mapName, config) {
@@ -179,7 +232,7 @@ document.addEventListener('DOMContentLoaded', () => {
       return;
     }
 
-    fetch(`/maps/${mapName}/locations.geojson`)
+    fetch(`${mapPath(mapName, config)}/locations.geojson${CONFIG.authQuery}`)
       .then(res => {
         if (!res.ok) throw new Error("No locations");
         return res.json();
@@ -243,9 +296,9 @@ document.addEventListener('DOMContentLoaded', () => {
   }
 
   function updateURL(mapName, layerType, doPushState) {
-    const path = `/${mapName}/${layerType}`;
+    const path = `${CONFIG.basePath}${mapName}/${layerType}`;
     if (doPushState && window.location.pathname !== path) {
-      window.history.pushState({ map: mapName, type: layerType }, "", path);
+      window.history.pushState({ map: mapName, type: layerType }, "", path + CONFIG.authQuery);
     }
   }
 
@@ -322,13 +375,26 @@ document.addEventListener('DOMContentLoaded', () => {
 
   window.setLayerType = (type) => {
     if (state.currentMap) {
-      if (type === 'topographic' && state.currentMap.no_topographic) return;
-      if (type === 'satellite' && state.currentMap.no_satellite) return;
+      const layer = findLayer(state.currentMap, type);
+      if (!layer || layer.overlay) return;
     }
     state.layerType = type;
     loadActiveMap(true);
   };
 
+  window.toggleOverlay = (name) => {
+    if (!state.currentMap) return;
+    if (state.overlays.has(name)) {
+      state.overlays.delete(name);
+      if (state.overlayTiles[name]) map.removeLayer(state.overlayTiles[name]);
+      delete state.overlayTiles[name];
+    } else {
+      state.overlays.add(name);
+      addOverlay(state.selectedMapName, state.currentMap, name);
+    }
+    validateLayerAvailability(state.currentMap);
+  };
+
   window.toggleLocations = () => {
     if (DOM.btnLoc.classList.contains('disabled')) return;
     state.showLocations = !state.showLocations;
@@ -385,7 +451,10 @@ document.addEventListener('DOMContentLoaded', () => {
   // --- INITIALIZATION ---
 
   function parseUrlAndLoad() {
-    const parts = window.location.pathname.split('/').filter(p => p.length > 0);
+    let pathname = window.location.pathname;
+    if (pathname.startsWith(CONFIG.basePath)) pathname = pathname.slice(CONFIG.basePath.length);
+
+    const parts = pathname.split('/').filter(p => p.length > 0);
     let mapToLoad = Object.keys(state.maps)[0]; // Default first map
 
     if (parts.length > 0 && state.maps[parts[0].toLowerCase()]) {
@@ -394,7 +463,7 @@ document.addEventListener('DOMContentLoaded', () => {
 
     if (parts.length > 1) {
       const t = parts[1].toLowerCase();
-      if (t === 'satellite' || t === 'topographic') state.layerType = t;
+      if (t) state.layerType = t;
     }
 
     if (mapToLoad) {
@@ -404,7 +473,7 @@ document.addEventListener('DOMContentLoaded', () => {
   }
 
   // Fetch Config
-  fetch('/api/maps')
+  fetch(`${CONFIG.basePath}api/maps${CONFIG.authQuery}`)
     .then(res => res.json())
     .then(data => {
       state.maps = {};
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/coord"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type Options struct {
+	ConfigFile string `short:"c" long:"config"   env:"CONFIG_FILE" description:"Path to configuration file" default:"config.yaml"`
+	Map        string `short:"m" long:"map"      description:"Map name or alias from configuration"`
+	From       string `short:"f" long:"from"     description:"Input coordinate system" choice:"game" choice:"wgs84" choice:"tile" choice:"grid" default:"game"`
+	Input      string `short:"i" long:"input"    description:"Format of stdin batches" choice:"auto" choice:"json" choice:"csv" default:"auto"`
+	Output     string `short:"o" long:"output"   description:"Output format" choice:"json" choice:"csv" default:"json"`
+	Layer      string `short:"l" long:"layer"    description:"Map layer used in tile URLs, defaults to the first base layer of the map"`
+	BaseURL    string `short:"b" long:"base-url" env:"BASE_URL" description:"Server URL to build tile URLs, e.g. https://map.example.com"`
+	Size       int    `short:"s" long:"size"     description:"Map size in meters, overrides the configuration"`
+	Zoom       *int   `short:"z" long:"zoom"     description:"Zoom level of the tile coordinates, defaults to the map zoom limit"`
+
+	Args struct {
+		Values []string `positional-arg-name:"VALUE" description:"Coordinates of a single point, reads a batch from stdin if empty"`
+	} `positional-args:"yes"`
+}
+
+func main() {
+	var opts Options
+	parser := flags.NewParser(&opts, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	conv, err := newConverter(&opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	from, err := coord.ParseSystem(opts.From)
+	if err != nil {
+		fatal(err)
+	}
+
+	// Read Input
+	var batch []coord.Input
+	single := len(opts.Args.Values) > 0
+
+	if single {
+		in, err := coord.ParseValues(from, opts.Args.Values)
+		if err != nil {
+			fatal(err)
+		}
+		batch = []coord.Input{in}
+	} else {
+		stdin := bufio.NewReader(os.Stdin)
+		if opts.Input == "json" || (opts.Input == "auto" && coord.IsJSON(stdin)) {
+			batch, err = coord.ReadJSON(stdin)
+		} else {
+			batch, err = coord.ReadCSV(stdin, from)
+		}
+		if err != nil {
+			fatal(fmt.Errorf("reading stdin: %w", err))
+		}
+	}
+
+	// Convert
+	positions := make([]coord.Position, 0, len(batch))
+	for i, in := range batch {
+		p, err := conv.Convert(in)
+		if err != nil {
+			fatal(fmt.Errorf("point %d: %w", i+1, err))
+		}
+		positions = append(positions, p)
+	}
+
+	// Write Output
+	if opts.Output == "csv" {
+		err = coord.WriteCSV(os.Stdout, positions)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if single {
+			err = enc.Encode(positions[0])
+		} else {
+			err = enc.Encode(positions)
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// newConverter resolves the map size and zoom from the configuration and options.
+func newConverter(opts *Options) (coord.Converter, error) {
+	conv := coord.Converter{Size: float64(opts.Size)}
+	name := opts.Map
+	layer := opts.Layer
+	zoom := 0
+
+	if opts.Map != "" {
+		cfg, err := config.Load(opts.ConfigFile)
+		if err != nil {
+			return conv, fmt.Errorf("loading configuration: %w", err)
+		}
+
+		world := cfg.FindMap(opts.Map)
+		if world == nil {
+			return conv, fmt.Errorf("map %q not found in configuration", opts.Map)
+		}
+		name = world.Name
+		if layer == "" {
+			layer = world.DefaultLayer()
+		}
+
+		if conv.Size <= 0 {
+			conv.Size = float64(world.Size)
+		}
+		zoom = world.ZoomLimit
+		if zoom <= 0 {
+			zoom = cfg.ZoomLimit
+		}
+	}
+
+	if conv.Size <= 0 {
+		return conv, fmt.Errorf("map size is unknown, set --map with a sized map or --size")
+	}
+
+	switch {
+	case opts.Zoom != nil:
+		conv.Zoom = *opts.Zoom
+	case zoom > 0:
+		conv.Zoom = zoom
+	default:
+		conv.Zoom = 6
+	}
+	if conv.Zoom < 0 || conv.Zoom > coord.MaxZoom {
+		return conv, fmt.Errorf("zoom must be between 0 and %d", coord.MaxZoom)
+	}
+
+	if opts.BaseURL != "" && name != "" && layer != "" {
+		conv.TileURL = strings.TrimRight(opts.BaseURL, "/") + "/maps/" + name + "/" + layer + "/{z}/{x}/{y}.webp"
+	}
+
+	return conv, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
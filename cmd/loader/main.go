@@ -17,14 +17,17 @@ import (
 type Options struct {
 	Logger logger.Logger `group:"Logger options"`
 
-	ConfigFile  string   `short:"c" long:"config"       env:"CONFIG_FILE"  description:"Path to configuration file" default:"config.yaml"`
-	Limit       []string `short:"l" long:"limit"        env:"LIMIT_NAMES"  description:"Limit processing to specific map names"`
-	Concurrency int      `short:"p" long:"concurrency"  env:"CONCURRENCY"  description:"Concurrency" default:"50"`
-	ZoomLimit   int      `short:"z" long:"zoom-limit"   env:"ZOOM_LIMIT"   description:"Tiles zoom limit" default:"6"`
-	TilesOnly   bool     `short:"t" long:"tiles-only"   description:"Download tiles only"`
-	GeoJSONOnly bool     `short:"g" long:"geojson-only" description:"Generate GeoJSON only"`
-	Force       bool     `short:"f" long:"force"        description:"Force overwrite of existing files"`
-	FastCheck   bool     `short:"F" long:"fast-check"   description:"Skip processing if cache exist"`
+	ConfigFile  string   `short:"c" long:"config"        env:"CONFIG_FILE"  description:"Path to configuration file" default:"config.yaml"`
+	Limit       []string `short:"l" long:"limit"         env:"LIMIT_NAMES"  description:"Limit processing to specific map names"`
+	Concurrency int      `short:"p" long:"concurrency"   env:"CONCURRENCY"  description:"Concurrency" default:"50"`
+	ZoomLimit   int      `short:"z" long:"zoom-limit"    env:"ZOOM_LIMIT"   description:"Tiles zoom limit" default:"6"`
+	TilesOnly   bool     `short:"t" long:"tiles-only"    description:"Download tiles only"`
+	GeoJSONOnly bool     `short:"g" long:"geojson-only"  description:"Generate GeoJSON only"`
+	Force       bool     `short:"f" long:"force"         description:"Force overwrite of existing files"`
+	FastCheck   bool     `short:"F" long:"fast-check"    description:"Skip processing if cache exist"`
+	PMTiles     bool     `short:"P" long:"pmtiles"       description:"Pack each map layer into a PMTiles archive"`
+	Prune       bool     `          long:"prune"         description:"Remove tile directories after packing into PMTiles"`
+	Manifest    bool     `short:"M" long:"manifest-only" description:"Only write the content hash manifests of the maps"`
 }
 
 func main() {
@@ -107,6 +110,11 @@ func main() {
 		Msg("Starting loader")
 
 	for _, world := range mapsToProcess {
+		if opts.Manifest {
+			writeManifest(world)
+			continue
+		}
+
 		hasLocations := world.LocationsURL != "" || world.LocationsInline != nil
 
 		if hasLocations && processGeo {
@@ -116,6 +124,7 @@ func main() {
 		}
 
 		if !processTiles {
+			writeManifest(world)
 			continue
 		}
 
@@ -126,7 +135,26 @@ func main() {
 			cfg.ZoomLimit,
 			opts.Force,
 			opts.FastCheck)
+
+		if opts.PMTiles {
+			if world.Attribution == "" {
+				world.Attribution = cfg.Attribution
+			}
+			if err := processor.PackPMTiles(world, cfg.ZoomLimit, opts.Prune); err != nil {
+				log.Error().Err(err).Str("map", world.Name).Msg("Failed to pack PMTiles archive")
+			}
+		}
+
+		writeManifest(world)
 	}
 
 	log.Info().Msg("Loader finished successfully")
 }
+
+// writeManifest updates the content hash manifest of the map, failures only cost
+// the server hashing the map on load.
+func writeManifest(world config.Map) {
+	if err := processor.WriteManifest(world); err != nil {
+		log.Error().Err(err).Str("map", world.Name).Msg("Failed to write manifest")
+	}
+}
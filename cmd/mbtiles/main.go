@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/logger"
+	"github.com/woozymasta/dzmap/internal/processor"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/rs/zerolog/log"
+)
+
+type Options struct {
+	Logger logger.Logger `group:"Logger options"`
+
+	Export ExportCommand `command:"export" description:"Pack a maps/{name}/{layer} tile pyramid into an MBTiles file"`
+	Import ImportCommand `command:"import" description:"Unpack an MBTiles file into the maps/{name}/{layer} tile pyramid"`
+
+	ConfigFile string `short:"c" long:"config" env:"CONFIG_FILE" description:"Path to configuration file" default:"config.yaml"`
+}
+
+// LayerOptions selects the map layer shared by both commands.
+type LayerOptions struct {
+	Map   string `short:"m" long:"map"   description:"Map name or alias from configuration" required:"true"`
+	Layer string `short:"l" long:"layer" description:"Map layer name, defaults to the first base layer of the map"`
+	Force bool   `short:"f" long:"force" description:"Force overwrite of existing files"`
+}
+
+type ExportCommand struct {
+	Output string `short:"o" long:"out" description:"Output MBTiles file. Defaults to {name}-{layer}.mbtiles"`
+	LayerOptions
+}
+
+type ImportCommand struct {
+	Input string `short:"i" long:"in" description:"Input MBTiles file" required:"true"`
+	LayerOptions
+}
+
+func main() {
+	var opts Options
+	parser := flags.NewParser(&opts, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	opts.Logger.Setup()
+
+	cfg, err := config.Load(opts.ConfigFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
+	switch parser.Active.Name {
+	case "export":
+		world := findMap(cfg, opts.Export.Map)
+		opts.Export.Layer = resolveLayer(world, opts.Export.Layer)
+		if world.Attribution == "" {
+			world.Attribution = cfg.Attribution
+		}
+
+		out := opts.Export.Output
+		if out == "" {
+			out = fmt.Sprintf("%s-%s.mbtiles", world.Name, opts.Export.Layer)
+		}
+
+		count, err := processor.ExportMBTiles(world, opts.Export.Layer, out, opts.Export.Force)
+		if err != nil {
+			log.Fatal().Err(err).Str("map", world.Name).Msg("Failed to export MBTiles")
+		}
+
+		log.Info().
+			Str("map", world.Name).
+			Str("layer", opts.Export.Layer).
+			Str("path", out).
+			Int("tiles", count).
+			Msg("MBTiles export finished")
+
+	case "import":
+		world := findMap(cfg, opts.Import.Map)
+		opts.Import.Layer = resolveLayer(world, opts.Import.Layer)
+
+		count, err := processor.ImportMBTiles(world, opts.Import.Layer, opts.Import.Input, opts.Import.Force)
+		if err != nil {
+			log.Fatal().Err(err).Str("map", world.Name).Msg("Failed to import MBTiles")
+		}
+
+		log.Info().
+			Str("map", world.Name).
+			Str("layer", opts.Import.Layer).
+			Str("path", opts.Import.Input).
+			Int("tiles", count).
+			Msg("MBTiles import finished")
+
+		if err := processor.WriteManifest(world); err != nil {
+			log.Error().Err(err).Str("map", world.Name).Msg("Failed to write manifest")
+		}
+	}
+}
+
+// findMap looks up a map by name or alias and exits if it is not configured.
+func findMap(cfg *config.Config, name string) config.Map {
+	world := cfg.FindMap(name)
+	if world == nil {
+		log.Fatal().Str("name", name).Msg("Map not found in configuration")
+		return config.Map{}
+	}
+
+	return *world
+}
+
+// resolveLayer returns the layer, the default layer of the map when empty,
+// and exits if it is not configured for the map.
+func resolveLayer(world config.Map, layer string) string {
+	if layer == "" {
+		layer = world.DefaultLayer()
+	}
+	if world.Layer(layer) == nil {
+		log.Fatal().Str("map", world.Name).Str("layer", layer).Msg("Layer not found in map configuration")
+	}
+
+	return layer
+}
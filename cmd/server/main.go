@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/woozymasta/dzmap/internal/config"
 	"github.com/woozymasta/dzmap/internal/logger"
+	"github.com/woozymasta/dzmap/internal/metrics"
 	"github.com/woozymasta/dzmap/internal/server"
 
 	"github.com/jessevdk/go-flags"
@@ -14,12 +20,36 @@ import (
 )
 
 type Options struct {
-	Logger logger.Logger `group:"Logger options"`
+	Logger    logger.Logger       `group:"Logger options"`
+	CORS      server.CORS         `group:"CORS options"`
+	RateLimit server.RateLimit    `group:"Rate limit options"`
+	Caching   server.Revalidation `group:"HTTP caching options"`
 
 	ConfigFile string `short:"c" long:"config"     env:"CONFIG_FILE"    description:"Path to configuration file" default:"config.yaml"`
 	Addr       string `short:"a" long:"addr"       env:"LISTEN_ADDRESS" description:"Address to listen on"       default:"0.0.0.0"`
 	Port       int    `short:"p" long:"port"       env:"LISTEN_PORT"    description:"Port to listen on"          default:"8080"`
 	ZoomLimit  int    `short:"z" long:"zoom-limit" env:"ZOOM_LIMIT"     description:"Tiles zoom limit"           default:"6"`
+
+	CacheSize int64 `long:"cache-size" env:"CACHE_SIZE" description:"In-memory tile cache size in MiB, 0 disables the cache" default:"0"`
+
+	BasePath       string   `long:"base-path"     env:"BASE_PATH"                     description:"Path prefix the server is mounted at behind a reverse proxy, e.g. /dzmap"`
+	TrustedProxies []string `long:"trusted-proxy" env:"TRUSTED_PROXIES" env-delim:"," description:"IP or CIDR of a reverse proxy whose X-Forwarded-* headers are trusted (repeatable)"`
+	AllowFraming   bool     `long:"allow-framing" env:"ALLOW_FRAMING"                 description:"Allow embedding the pages in frames of other sites"`
+
+	WatchConfig   bool          `short:"w" long:"watch"          env:"WATCH_CONFIG"   description:"Reload configuration when the file or a map manifest changes"`
+	WatchInterval time.Duration `          long:"watch-interval" env:"WATCH_INTERVAL" description:"Configuration file poll interval" default:"5s"`
+
+	TLSCert string `long:"tls-cert" env:"TLS_CERT_FILE" description:"TLS certificate file (PEM), enables HTTPS and HTTP/2 together with --tls-key, reloaded on change"`
+	TLSKey  string `long:"tls-key"  env:"TLS_KEY_FILE"  description:"TLS private key file (PEM)"`
+	H2C     bool   `long:"h2c"      env:"H2C"           description:"Accept HTTP/2 without TLS (h2c), e.g. from a reverse proxy"`
+
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" env:"READ_HEADER_TIMEOUT" description:"Time to read request headers"                                default:"10s"`
+	ReadTimeout       time.Duration `long:"read-timeout"        env:"READ_TIMEOUT"        description:"Time to read the whole request, 0 disables"                  default:"30s"`
+	WriteTimeout      time.Duration `long:"write-timeout"       env:"WRITE_TIMEOUT"       description:"Time to write the response, 0 disables (large archives)"     default:"0s"`
+	IdleTimeout       time.Duration `long:"idle-timeout"        env:"IDLE_TIMEOUT"        description:"Time to keep idle keep-alive connections"                    default:"120s"`
+	MaxHeaderSize     int           `long:"max-header-size"     env:"MAX_HEADER_SIZE"     description:"Maximum size of request headers in KiB"                      default:"64"`
+	ShutdownTimeout   time.Duration `long:"shutdown-timeout"    env:"SHUTDOWN_TIMEOUT"    description:"Time for in-flight requests to finish on SIGTERM or SIGINT"  default:"30s"`
+	ShutdownDelay     time.Duration `long:"shutdown-delay"      env:"SHUTDOWN_DELAY"      description:"Time to keep serving with failing readiness before shutdown" default:"0s"`
 }
 
 func main() {
@@ -35,39 +65,146 @@ func main() {
 	// Setup Logging
 	opts.Logger.Setup()
 
+	basePath := server.NormalizeBasePath(opts.BasePath)
+	proxies, err := server.ParseTrustedProxies(opts.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid trusted proxies")
+	}
+
 	// Load Config
-	cfg, err := config.Load(opts.ConfigFile)
+	load := func() (*server.ServerContext, error) {
+		cfg, err := config.Load(opts.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+
+		if cfg.ZoomLimit <= 0 {
+			if opts.ZoomLimit <= 0 {
+				cfg.ZoomLimit = 6
+			} else {
+				cfg.ZoomLimit = opts.ZoomLimit
+			}
+		}
+
+		return server.NewServerContext(cfg, server.Options{
+			BasePath:         basePath,
+			TrustedProxies:   proxies,
+			TileCacheControl: opts.Caching.CacheControl(),
+			TileCacheSize:    opts.CacheSize << 20,
+		}), nil
+	}
+
+	holder, err := server.NewHolder(load)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
-	if cfg.ZoomLimit <= 0 {
-		if opts.ZoomLimit <= 0 {
-			cfg.ZoomLimit = 6
-		} else {
-			cfg.ZoomLimit = opts.ZoomLimit
+	// Reload on SIGHUP and optionally when the config file changes
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info().Msg("SIGHUP received, reloading configuration")
+			_ = holder.Reload()
 		}
-	}
+	}()
 
-	srvCtx := server.NewServerContext(cfg)
+	stopWatch := make(chan struct{})
+	if opts.WatchConfig {
+		go holder.Watch(opts.ConfigFile, opts.WatchInterval, stopWatch)
+	}
 
 	// Routes
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/maps", srvCtx.HandleMapsList)
-	mux.HandleFunc("/favicon.ico", srvCtx.HandleFavicon)
-	mux.HandleFunc("/maps/", srvCtx.HandleTileOrLoc)
-	mux.HandleFunc("/", srvCtx.HandleIndex)
+	mux.HandleFunc("/api/maps", holder.Handler((*server.ServerContext).HandleMapsList))
+	mux.HandleFunc("/api/maps/", holder.Handler((*server.ServerContext).HandleMapAPI))
+	mux.HandleFunc("/api/convert", holder.Handler((*server.ServerContext).HandleConvert))
+	mux.HandleFunc("/api/static/", holder.Handler((*server.ServerContext).HandleStaticMap))
+	mux.HandleFunc("/api/search", holder.Handler((*server.ServerContext).HandleSearch))
+	mux.HandleFunc("/favicon.ico", holder.Handler((*server.ServerContext).HandleFavicon))
+	mux.HandleFunc("/maps/", holder.Handler((*server.ServerContext).HandleTileOrLoc))
+	mux.HandleFunc("/wmts", holder.Handler((*server.ServerContext).HandleWMTS))
+	mux.HandleFunc("/wmts/", holder.Handler((*server.ServerContext).HandleWMTS))
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/healthz", holder.HandleHealth)
+	mux.HandleFunc("/readyz", holder.HandleReady)
+	mux.HandleFunc("/", holder.Handler((*server.ServerContext).HandleIndex))
 
-	handler := server.RequestLogger(mux)
+	limited := opts.RateLimit.Handler(mux, holder.IsAPIKey, proxies)
+	handler := server.RequestLogger(server.SecurityHeaders(opts.CORS.Handler(server.MountAt(basePath, limited)), opts.AllowFraming))
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(opts.H2C)
 
 	listenAddr := fmt.Sprintf("%s:%d", opts.Addr, opts.Port)
+	srv := &http.Server{
+		Addr:              listenAddr,
+		Handler:           handler,
+		Protocols:         protocols,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+		MaxHeaderBytes:    opts.MaxHeaderSize << 10,
+	}
+
+	serve := srv.ListenAndServe
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		certs, err := server.NewCertReloader(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load TLS certificate")
+		}
+
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+
 	log.Info().
 		Str("addr", listenAddr).
-		Int("maps_loaded", len(cfg.Maps)).
-		Int("default_zoom", cfg.ZoomLimit).
+		Str("base_path", basePath).
+		Int("maps_loaded", len(holder.Current().Config.Maps)).
+		Int("default_zoom", holder.Current().Config.ZoomLimit).
+		Bool("watch_config", opts.WatchConfig).
+		Bool("tls", srv.TLSConfig != nil).
 		Msg("Web server started")
 
-	if err := http.ListenAndServe(listenAddr, handler); err != nil {
+	served := make(chan error, 1)
+	go func() { served <- serve() }()
+
+	// Drain in-flight requests on SIGTERM and SIGINT
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case err := <-served:
 		log.Fatal().Err(err).Msg("Server failed")
+	case sig := <-stop:
+		log.Info().Str("signal", sig.String()).Msg("Shutting down, draining in-flight requests")
+	}
+
+	// let load balancers notice the failing readiness check before closing listeners
+	holder.Drain()
+	close(stopWatch)
+	time.Sleep(opts.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Graceful shutdown failed, closing remaining connections")
+		_ = srv.Close()
 	}
+	if err := holder.Current().Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close server context")
+	}
+
+	log.Info().Msg("Web server stopped")
 }
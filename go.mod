@@ -5,6 +5,7 @@ go 1.25.0
 require (
 	github.com/chai2010/webp v1.4.0
 	github.com/jessevdk/go-flags v1.6.1
+	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/rs/zerolog v1.34.0
 	github.com/tdewolff/minify/v2 v2.24.7
 	golang.org/x/image v0.33.0
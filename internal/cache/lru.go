@@ -0,0 +1,93 @@
+// Package cache provides an in-memory least-recently-used cache bounded by size.
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a least-recently-used cache bounded by the total size of its entries.
+// It is safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	ll     *list.List
+	items  map[K]*list.Element
+	size   int64
+	budget int64
+	mu     sync.Mutex
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+// NewLRU creates a cache holding entries up to budget bytes in total.
+func NewLRU[K comparable, V any](budget int64) *LRU[K, V] {
+	return &LRU[K, V]{
+		ll:     list.New(),
+		items:  make(map[K]*list.Element),
+		budget: budget,
+	}
+}
+
+// Get returns the cached value and marks it as recently used.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*entry[K, V]).value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Add stores a value of the given size, evicting the least recently used entries
+// until the budget is met. Values larger than the whole budget are not stored.
+func (c *LRU[K, V]) Add(key K, value V, size int64) {
+	if size > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		c.size += size - e.size
+		e.value, e.size = value, size
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, size: size})
+		c.size += size
+	}
+
+	for c.size > c.budget {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		e := c.ll.Remove(oldest).(*entry[K, V])
+		delete(c.items, e.key)
+		c.size -= e.size
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// Size returns the total size of cached entries.
+func (c *LRU[K, V]) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
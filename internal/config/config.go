@@ -2,18 +2,93 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 
 	"github.com/woozymasta/dzmap/internal/geo"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Map visibility values.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// Responses for tiles missing in a layer and its fallback layers.
+const (
+	// MissingTransparent serves a transparent tile (default)
+	MissingTransparent = "transparent"
+	// MissingNoContent answers 204 No Content
+	MissingNoContent = "no_content"
+	// MissingNotFound answers 404 Not Found
+	MissingNotFound = "not_found"
+)
+
 // Config represents the root configuration file structure.
 type Config struct {
 	Attribution string `yaml:"attribution,omitempty" json:"attribution,omitempty"`
 	Maps        []Map  `yaml:"maps" json:"maps"`
 	ZoomLimit   int    `yaml:"zoom,omitempty"`
+	// Missing is the default response for missing tiles of all maps
+	Missing string `yaml:"missing,omitempty" json:"-"`
+
+	Secrets `yaml:",inline"`
+
+	// SecretsFile is a YAML file with more api_keys and the url_secret,
+	// relative to the configuration file
+	SecretsFile string `yaml:"secrets_file,omitempty" json:"-"`
+}
+
+// Secrets holds the credentials granting access to private maps.
+// They are set in the configuration file or in the separate secrets file.
+type Secrets struct {
+	// URLSecret is the HMAC key of signed URLs, they are disabled when empty
+	URLSecret string   `yaml:"url_secret,omitempty" json:"-"`
+	APIKeys   []APIKey `yaml:"api_keys,omitempty" json:"-"`
+}
+
+// APIKey grants access to private maps.
+type APIKey struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// names of the private maps the key can access, all when empty
+	Maps []string `yaml:"maps,omitempty"`
+}
+
+// Names of the layers set by the legacy topographic and satellite map fields.
+const (
+	LayerTopographic = "topographic"
+	LayerSatellite   = "satellite"
+)
+
+// layerNameRe restricts layer names to safe URL path segments.
+var layerNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// reservedLayers are names used by other routes under /maps/{name}/.
+var reservedLayers = []string{"locations"}
+
+// Layer is a named tile layer of a map.
+type Layer struct {
+	Name string `yaml:"name" json:"name"`
+	// URL template or single image the loader builds the tiles from
+	Source      string `yaml:"source,omitempty" json:"-"`
+	Attribution string `yaml:"attribution,omitempty" json:"attribution,omitempty"`
+	// layers tried in order for tiles missing in this one
+	Fallback []string `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+	// response for tiles missing in the layer and its fallbacks, see MissingTransparent
+	Missing string `yaml:"missing,omitempty" json:"missing,omitempty"`
+	// synthesize missing tiles from other zoom levels, see Synthesizes
+	Synthesize *bool `yaml:"synthesize,omitempty" json:"-"`
+	ZoomLimit  int   `yaml:"zoom,omitempty" json:"zoom"`
+	TileSize   int   `yaml:"tile_size,omitempty" json:"-"` // only when processing single image
+	// overlays are drawn over a base layer and are transparent where empty
+	Overlay bool `yaml:"overlay,omitempty" json:"overlay,omitempty"`
 }
 
 // Map represents a single game map configuration.
@@ -23,17 +98,25 @@ type Map struct {
 	// defining GeoJSON directly in config.yaml
 	LocationsInline *geo.GeoJSONFeatureCollection `yaml:"locations_geojson,omitempty" json:"-"`
 
+	// Layers of the map, the topographic and satellite fields add the legacy layers
+	Layers []Layer `yaml:"layers,omitempty" json:"layers"`
+
 	Name              string   `yaml:"name" json:"name"`
-	Topographic       string   `yaml:"topographic" json:"-"`
-	Satellite         string   `yaml:"satellite" json:"-"`
+	Topographic       string   `yaml:"topographic,omitempty" json:"-"`
+	Satellite         string   `yaml:"satellite,omitempty" json:"-"`
 	LocationsURL      string   `yaml:"locations,omitempty" json:"-"`
+	Visibility        string   `yaml:"visibility,omitempty" json:"visibility,omitempty"` // public (default) or private
 	Attribution       string   `yaml:"attribution,omitempty" json:"attribution,omitempty"`
+	Missing           string   `yaml:"missing,omitempty" json:"-"`    // default of the map layers
+	Synthesize        *bool    `yaml:"synthesize,omitempty" json:"-"` // default of the map layers
 	Aliases           []string `yaml:"aliases,omitempty" json:"-"`
 	ID                uint64   `yaml:"id" json:"id"` // Steam Workshop or App ID
 	ZoomLimit         int      `yaml:"zoom,omitempty" json:"zoom"`
 	Size              int      `yaml:"size,omitempty" json:"size"`
 	TileSize          int      `yaml:"tile_size,omitempty" json:"-"` // only when processing single image
 	LocationsIzurvive bool     `yaml:"locations_izurvive,omitempty" json:"-"`
+	NoFallback        bool     `yaml:"no_fallback,omitempty" json:"-"` // ignore fallback of all layers
+	Version           string   `yaml:"-" json:"version,omitempty"`     // content hash set by the server, see manifest
 	NoTopographic     bool     `yaml:"-" json:"no_topographic,omitempty"`
 	NoSatellite       bool     `yaml:"-" json:"no_satellite,omitempty"`
 }
@@ -50,5 +133,207 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if cfg.SecretsFile != "" {
+		secretsPath := cfg.SecretsFile
+		if !filepath.IsAbs(secretsPath) {
+			secretsPath = filepath.Join(filepath.Dir(path), secretsPath)
+		}
+
+		data, err := os.ReadFile(secretsPath)
+		if err != nil {
+			return nil, fmt.Errorf("secrets file: %w", err)
+		}
+
+		var secrets Secrets
+		if err := yaml.Unmarshal(data, &secrets); err != nil {
+			return nil, fmt.Errorf("secrets file %s: %w", secretsPath, err)
+		}
+
+		cfg.APIKeys = append(cfg.APIKeys, secrets.APIKeys...)
+		if secrets.URLSecret != "" {
+			cfg.URLSecret = secrets.URLSecret
+		}
+	}
+
+	for i := range cfg.Maps {
+		cfg.Maps[i].addLegacyLayers()
+	}
+
 	return &cfg, nil
 }
+
+// addLegacyLayers appends the layers set by the topographic and satellite fields,
+// unless the layers list already defines them. As before the layers list existed,
+// each of them falls back to the other.
+func (m *Map) addLegacyLayers() {
+	legacy := []Layer{
+		{Name: LayerTopographic, Source: m.Topographic, Fallback: []string{LayerSatellite}},
+		{Name: LayerSatellite, Source: m.Satellite, Fallback: []string{LayerTopographic}},
+	}
+
+	for _, l := range legacy {
+		if l.Source == "" || m.Layer(l.Name) != nil {
+			continue
+		}
+		l.TileSize = m.TileSize
+		m.Layers = append(m.Layers, l)
+	}
+}
+
+// FindMap returns the map with the given name or alias, or nil.
+func (c *Config) FindMap(name string) *Map {
+	for i := range c.Maps {
+		if c.Maps[i].Name == name || slices.Contains(c.Maps[i].Aliases, name) {
+			return &c.Maps[i]
+		}
+	}
+
+	return nil
+}
+
+// Layer returns the layer of the map by name, or nil.
+func (m *Map) Layer(name string) *Layer {
+	for i := range m.Layers {
+		if m.Layers[i].Name == name {
+			return &m.Layers[i]
+		}
+	}
+
+	return nil
+}
+
+// Synthesizes reports whether tiles missing in the layer and its fallbacks are
+// synthesized from other zoom levels. Unless set, only layers answering missing
+// tiles with a transparent tile synthesize, so not_found and no_content reach clients.
+func (l *Layer) Synthesizes() bool {
+	if l.Synthesize != nil {
+		return *l.Synthesize
+	}
+
+	return l.Missing == "" || l.Missing == MissingTransparent
+}
+
+// DefaultLayer returns the name of the layer shown when none is requested:
+// the first base layer, or the first layer when there are only overlays.
+func (m *Map) DefaultLayer() string {
+	for _, l := range m.Layers {
+		if !l.Overlay {
+			return l.Name
+		}
+	}
+	if len(m.Layers) > 0 {
+		return m.Layers[0].Name
+	}
+
+	return ""
+}
+
+// IsPrivate reports whether the map is only served to requests with access to it.
+func (m *Map) IsPrivate() bool {
+	return m.Visibility == VisibilityPrivate
+}
+
+// Validate checks the configuration for errors that would make maps unreachable,
+// such as missing names or names and aliases used more than once.
+func (c *Config) Validate() error {
+	var errs []error
+	seen := make(map[string]string)
+
+	if !validMissing(c.Missing) {
+		errs = append(errs, fmt.Errorf("missing: %s", missingError))
+	}
+
+	for i, m := range c.Maps {
+		if m.Name == "" {
+			errs = append(errs, fmt.Errorf("maps[%d]: name is required", i))
+			continue
+		}
+
+		if m.Visibility != "" && m.Visibility != VisibilityPublic && m.Visibility != VisibilityPrivate {
+			errs = append(errs, fmt.Errorf("map %q: visibility must be %q or %q", m.Name, VisibilityPublic, VisibilityPrivate))
+		}
+
+		if !validMissing(m.Missing) {
+			errs = append(errs, fmt.Errorf("map %q: missing: %s", m.Name, missingError))
+		}
+
+		errs = append(errs, m.validateLayers()...)
+
+		for _, name := range append([]string{m.Name}, m.Aliases...) {
+			if owner, ok := seen[name]; ok {
+				errs = append(errs, fmt.Errorf("map %q: name %q is already used by map %q", m.Name, name, owner))
+				continue
+			}
+			seen[name] = m.Name
+		}
+	}
+
+	keys := make(map[string]string, len(c.APIKeys))
+	for i, k := range c.APIKeys {
+		name := k.Name
+		if name == "" {
+			name = fmt.Sprintf("api_keys[%d]", i)
+		}
+
+		if k.Key == "" {
+			errs = append(errs, fmt.Errorf("api key %q: key is required", name))
+			continue
+		}
+		if owner, ok := keys[k.Key]; ok {
+			errs = append(errs, fmt.Errorf("api key %q: key is already used by %q", name, owner))
+			continue
+		}
+		keys[k.Key] = name
+
+		for _, m := range k.Maps {
+			if seen[m] != m {
+				errs = append(errs, fmt.Errorf("api key %q: unknown map %q", name, m))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateLayers checks layer names and fallback references of the map.
+func (m *Map) validateLayers() []error {
+	var errs []error
+	names := make(map[string]bool, len(m.Layers))
+
+	for i, l := range m.Layers {
+		switch {
+		case !layerNameRe.MatchString(l.Name):
+			errs = append(errs, fmt.Errorf("map %q: layers[%d]: name %q must be lowercase letters, digits, '-' or '_'", m.Name, i, l.Name))
+		case slices.Contains(reservedLayers, l.Name):
+			errs = append(errs, fmt.Errorf("map %q: layer name %q is reserved", m.Name, l.Name))
+		case names[l.Name]:
+			errs = append(errs, fmt.Errorf("map %q: layer %q is defined more than once", m.Name, l.Name))
+		case !validMissing(l.Missing):
+			errs = append(errs, fmt.Errorf("map %q: layer %q: missing: %s", m.Name, l.Name, missingError))
+		}
+		names[l.Name] = true
+	}
+
+	for _, l := range m.Layers {
+		for _, fb := range l.Fallback {
+			if fb == l.Name || m.Layer(fb) == nil {
+				errs = append(errs, fmt.Errorf("map %q: layer %q: unknown fallback layer %q", m.Name, l.Name, fb))
+			}
+		}
+	}
+
+	return errs
+}
+
+// missingError describes the accepted missing tile responses.
+var missingError = fmt.Sprintf("must be %q, %q or %q", MissingTransparent, MissingNoContent, MissingNotFound)
+
+// validMissing reports whether v is a missing tile response, empty for the default.
+func validMissing(v string) bool {
+	switch v {
+	case "", MissingTransparent, MissingNoContent, MissingNotFound:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,361 @@
+// Package coord converts points between the coordinate systems of a game map
+// and reads and writes batches of them as JSON or CSV.
+package coord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/geo"
+)
+
+// DefaultTileSize is the pixel size of map tiles.
+const DefaultTileSize = 256
+
+// MaxZoom is the deepest zoom level tile coordinates may use.
+const MaxZoom = 30
+
+// System is a coordinate system points can be given in.
+type System string
+
+// Supported input coordinate systems.
+const (
+	Game  System = "game"  // x, z in metres, optionally x, z, height as in ADM logs
+	WGS84 System = "wgs84" // lon, lat as used by MetricZ
+	Tile  System = "tile"  // z, x, y and optional pixel px, py
+	Grid  System = "grid"  // in-game grid reference "045 123"
+)
+
+var (
+	// ErrNoCoordinates is returned for an input without any coordinates.
+	ErrNoCoordinates = errors.New("no coordinates given")
+	// ErrUnknownSystem is returned for an unsupported coordinate system name.
+	ErrUnknownSystem = errors.New("unknown coordinate system")
+)
+
+// Input is a point in one of the supported systems, exactly one of them should be set.
+type Input struct {
+	X    *float64       `json:"x,omitempty"`
+	Z    *float64       `json:"z,omitempty"`
+	Lon  *float64       `json:"lon,omitempty"`
+	Lat  *float64       `json:"lat,omitempty"`
+	Tile *geo.TileCoord `json:"tile,omitempty"`
+	Grid string         `json:"grid,omitempty"`
+}
+
+// UnmarshalJSON decodes an input, a tile without px or py points at the centre
+// of the tile as in ParseValues.
+func (in *Input) UnmarshalJSON(data []byte) error {
+	type plain Input
+	var aux struct {
+		*plain
+		Tile json.RawMessage `json:"tile"`
+	}
+	aux.plain = (*plain)(in)
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	in.Tile = nil
+	if len(aux.Tile) > 0 && !bytes.Equal(aux.Tile, []byte("null")) {
+		t := geo.TileCoord{PX: DefaultTileSize / 2, PY: DefaultTileSize / 2}
+		if err := json.Unmarshal(aux.Tile, &t); err != nil {
+			return err
+		}
+		in.Tile = &t
+	}
+
+	return nil
+}
+
+// Position is a point expressed in every supported system.
+type Position struct {
+	Grid    string        `json:"grid"`
+	TileURL string        `json:"tile_url,omitempty"`
+	Tile    geo.TileCoord `json:"tile"`
+	X       float64       `json:"x"`
+	Z       float64       `json:"z"`
+	Lon     float64       `json:"lon"`
+	Lat     float64       `json:"lat"`
+}
+
+// Converter converts points of one map.
+type Converter struct {
+	// TileURL is a template with {z}, {x} and {y} placeholders, empty omits tile URLs
+	TileURL  string
+	Size     float64
+	Zoom     int
+	TileSize int
+}
+
+// ParseSystem parses a coordinate system name.
+func ParseSystem(name string) (System, error) {
+	switch s := System(strings.ToLower(strings.TrimSpace(name))); s {
+	case Game, WGS84, Tile, Grid:
+		return s, nil
+	case "", "xz":
+		return Game, nil
+	case "lonlat", "latlon", "epsg:4326", "metricz":
+		return WGS84, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownSystem, name)
+	}
+}
+
+// Convert resolves the input to game coordinates and expresses it in every system.
+func (c Converter) Convert(in Input) (Position, error) {
+	tileSize := c.TileSize
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	var x, z float64
+	switch {
+	case in.X != nil && in.Z != nil:
+		if !finite(*in.X, *in.Z) {
+			return Position{}, fmt.Errorf("x/z %g,%g is not a finite number", *in.X, *in.Z)
+		}
+		x, z = *in.X, *in.Z
+
+	case in.Lon != nil && in.Lat != nil:
+		if !finite(*in.Lon, *in.Lat) || math.Abs(*in.Lat) > geo.MaxLat || math.Abs(*in.Lon) > 180 {
+			return Position{}, fmt.Errorf("lon/lat %g,%g is out of range", *in.Lon, *in.Lat)
+		}
+		x, z = geo.MetricZToGame(*in.Lon, *in.Lat, c.Size)
+
+	case in.Tile != nil:
+		t := *in.Tile
+		if t.Z < 0 || t.Z > MaxZoom {
+			return Position{}, fmt.Errorf("tile zoom %d is out of range 0..%d", t.Z, MaxZoom)
+		}
+		n := 1 << t.Z
+		if t.X < 0 || t.Y < 0 || t.X >= n || t.Y >= n ||
+			t.PX < 0 || t.PY < 0 || t.PX >= tileSize || t.PY >= tileSize {
+			return Position{}, fmt.Errorf("tile %d/%d/%d px %d,%d is out of range", t.Z, t.X, t.Y, t.PX, t.PY)
+		}
+		x, z = geo.TileCoordToGame(t, c.Size, tileSize)
+
+	case in.Grid != "":
+		var err error
+		if x, z, err = geo.GridRefToGame(in.Grid, c.Size); err != nil {
+			return Position{}, err
+		}
+
+	default:
+		return Position{}, ErrNoCoordinates
+	}
+
+	if c.Zoom < 0 || c.Zoom > MaxZoom {
+		return Position{}, fmt.Errorf("zoom %d is out of range 0..%d", c.Zoom, MaxZoom)
+	}
+
+	p := Position{X: x, Z: z}
+	p.Lon, p.Lat = geo.GameToMetricZ(x, z, c.Size)
+	p.Grid = geo.GameToGridRef(x, z, c.Size)
+	p.Tile = geo.GameToTileCoord(x, z, c.Size, c.Zoom, tileSize)
+
+	if c.TileURL != "" {
+		p.TileURL = strings.NewReplacer(
+			"{z}", strconv.Itoa(p.Tile.Z),
+			"{x}", strconv.Itoa(p.Tile.X),
+			"{y}", strconv.Itoa(p.Tile.Y),
+		).Replace(c.TileURL)
+	}
+
+	return p, nil
+}
+
+// ParseValues builds an input from the text values of a single point in the given system,
+// such as a CSV record or command line arguments.
+// Brackets and commas as in "<4500.1, 10200.3, 320.5>" are ignored.
+func ParseValues(from System, values []string) (Input, error) {
+	var fields []string
+	for _, v := range values {
+		fields = append(fields, strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == '<' || r == '>' || r == '[' || r == ']' || r == ';' || r == ' ' || r == '\t'
+		})...)
+	}
+
+	if from == Grid {
+		if len(fields) == 0 {
+			return Input{}, ErrNoCoordinates
+		}
+		return Input{Grid: strings.Join(fields, " ")}, nil
+	}
+
+	nums := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || !finite(v) {
+			return Input{}, fmt.Errorf("invalid number %q", f)
+		}
+		nums[i] = v
+	}
+
+	switch from {
+	case Game:
+		switch len(nums) {
+		case 2:
+			return Input{X: &nums[0], Z: &nums[1]}, nil
+		case 3: // x, z, height as in ADM logs: pos=<x, z, height>
+			return Input{X: &nums[0], Z: &nums[1]}, nil
+		}
+		return Input{}, fmt.Errorf("game coordinates need x,z or x,z,height, got %d values", len(nums))
+
+	case WGS84:
+		if len(nums) != 2 {
+			return Input{}, fmt.Errorf("wgs84 coordinates need lon,lat, got %d values", len(nums))
+		}
+		return Input{Lon: &nums[0], Lat: &nums[1]}, nil
+
+	case Tile:
+		if len(nums) != 3 && len(nums) != 5 {
+			return Input{}, fmt.Errorf("tile coordinates need z,x,y or z,x,y,px,py, got %d values", len(nums))
+		}
+		t := geo.TileCoord{Z: int(nums[0]), X: int(nums[1]), Y: int(nums[2])}
+		if len(nums) == 5 {
+			t.PX, t.PY = int(nums[3]), int(nums[4])
+		} else {
+			t.PX, t.PY = DefaultTileSize/2, DefaultTileSize/2
+		}
+		return Input{Tile: &t}, nil
+	}
+
+	return Input{}, fmt.Errorf("%w %q", ErrUnknownSystem, from)
+}
+
+// finite reports whether none of the values is NaN or infinite.
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReadJSON reads a single input object or an array of them.
+func ReadJSON(r io.Reader) ([]Input, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var in Input
+		if err := json.Unmarshal(data, &in); err != nil {
+			return nil, err
+		}
+		return []Input{in}, nil
+	}
+
+	var batch []Input
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// ReadCSV reads one point per record in the given system.
+// A first record without any number is treated as a header and skipped.
+func ReadCSV(r io.Reader, from System) ([]Input, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	var batch []Input
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && isHeader(rec) {
+			continue
+		}
+
+		in, err := ParseValues(from, rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		batch = append(batch, in)
+	}
+
+	return batch, nil
+}
+
+// isHeader reports whether none of the fields of a CSV record is a number.
+func isHeader(rec []string) bool {
+	for _, f := range rec {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(f), 64); err == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsJSON reports whether the buffered input starts like a JSON document.
+func IsJSON(r *bufio.Reader) bool {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return false
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = r.ReadByte()
+			continue
+		case '{', '[':
+			return true
+		}
+		return false
+	}
+}
+
+// CSVHeader is the header row written by WriteCSV.
+var CSVHeader = []string{"x", "z", "lon", "lat", "grid", "tile_z", "tile_x", "tile_y", "px", "py", "tile_url"}
+
+// WriteCSV writes positions with a header row.
+func WriteCSV(w io.Writer, positions []Position) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+
+	for _, p := range positions {
+		if err := cw.Write([]string{
+			strconv.FormatFloat(p.X, 'f', 2, 64),
+			strconv.FormatFloat(p.Z, 'f', 2, 64),
+			strconv.FormatFloat(p.Lon, 'f', 7, 64),
+			strconv.FormatFloat(p.Lat, 'f', 7, 64),
+			p.Grid,
+			strconv.Itoa(p.Tile.Z),
+			strconv.Itoa(p.Tile.X),
+			strconv.Itoa(p.Tile.Y),
+			strconv.Itoa(p.Tile.PX),
+			strconv.Itoa(p.Tile.PY),
+			p.TileURL,
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
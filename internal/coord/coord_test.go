@@ -0,0 +1,173 @@
+package coord
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/woozymasta/dzmap/internal/geo"
+)
+
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   System
+		values []string
+		want   Input
+		err    bool
+	}{
+		{name: "game x z", from: Game, values: []string{"4550.1", "10200.3"}, want: Input{X: ptr(4550.1), Z: ptr(10200.3)}},
+		{name: "adm log position", from: Game, values: []string{"<4550.1, 10200.3, 320.5>"}, want: Input{X: ptr(4550.1), Z: ptr(10200.3)}},
+		{name: "game one value", from: Game, values: []string{"4550.1"}, err: true},
+		{name: "game nan", from: Game, values: []string{"NaN", "1"}, err: true},
+		{name: "game inf", from: Game, values: []string{"1", "-Inf"}, err: true},
+		{name: "wgs84", from: WGS84, values: []string{"12.5", "-40"}, want: Input{Lon: ptr(12.5), Lat: ptr(-40)}},
+		{name: "wgs84 nan", from: WGS84, values: []string{"nan", "0"}, err: true},
+		{name: "tile", from: Tile, values: []string{"3", "2", "1"}, want: Input{Tile: &geo.TileCoord{Z: 3, X: 2, Y: 1, PX: 128, PY: 128}}},
+		{name: "tile pixel", from: Tile, values: []string{"3", "2", "1", "10", "20"}, want: Input{Tile: &geo.TileCoord{Z: 3, X: 2, Y: 1, PX: 10, PY: 20}}},
+		{name: "grid", from: Grid, values: []string{"045", "123"}, want: Input{Grid: "045 123"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := ParseValues(tt.from, tt.values)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseValues() = %+v, want an error", in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseValues(): %v", err)
+			}
+			// DeepEqual compares the values behind the pointers
+			if !reflect.DeepEqual(in, tt.want) {
+				t.Errorf("ParseValues() = %+v, want %+v", in, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Input
+	}{
+		{"tile without pixel", `{"tile": {"z": 3, "x": 2, "y": 1}}`, []Input{{Tile: &geo.TileCoord{Z: 3, X: 2, Y: 1, PX: 128, PY: 128}}}},
+		{"tile pixel", `[{"tile": {"z": 3, "x": 2, "y": 1, "px": 0, "py": 20}}]`, []Input{{Tile: &geo.TileCoord{Z: 3, X: 2, Y: 1, PX: 0, PY: 20}}}},
+		{"tile null", `{"tile": null, "grid": "045 123"}`, []Input{{Grid: "045 123"}}},
+		{"game", `[{"x": 4550.1, "z": 10200.3}]`, []Input{{X: ptr(4550.1), Z: ptr(10200.3)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := ReadJSON(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ReadJSON(): %v", err)
+			}
+			if !reflect.DeepEqual(batch, tt.want) {
+				t.Errorf("ReadJSON() = %+v, want %+v", batch, tt.want)
+			}
+		})
+	}
+
+	// JSON and text input of the same tile give the same point
+	conv := Converter{Size: 15360, Zoom: 6}
+	fromJSON, err := ReadJSON(strings.NewReader(`{"tile": {"z": 3, "x": 2, "y": 1}}`))
+	if err != nil {
+		t.Fatalf("ReadJSON(): %v", err)
+	}
+	fromText, err := ParseValues(Tile, []string{"3", "2", "1"})
+	if err != nil {
+		t.Fatalf("ParseValues(): %v", err)
+	}
+	a, errA := conv.Convert(fromJSON[0])
+	b, errB := conv.Convert(fromText)
+	if errA != nil || errB != nil || a != b {
+		t.Errorf("Convert() JSON = %+v (%v), text = %+v (%v)", a, errA, b, errB)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		from System
+		data string
+		want int
+		err  bool
+	}{
+		{name: "header", from: Game, data: "x,z\n4550.1,10200.3\n1,2\n", want: 2},
+		{name: "no header", from: Game, data: "4550.1,10200.3\n1,2\n", want: 2},
+		{name: "invalid first line", from: Game, data: "4550.1,abc\n1,2\n", err: true},
+		{name: "first line with too few values", from: Game, data: "4550.1\n1,2\n", err: true},
+		{name: "grid header", from: Grid, data: "grid\n045 123\n", want: 1},
+		{name: "invalid later line", from: Game, data: "x,z\n1,2\nx,z\n", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := ReadCSV(strings.NewReader(tt.data), tt.from)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ReadCSV() = %+v, want an error", batch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadCSV(): %v", err)
+			}
+			if len(batch) != tt.want {
+				t.Errorf("ReadCSV() read %d points, want %d", len(batch), tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertRejectsInvalidInput(t *testing.T) {
+	conv := Converter{Size: 15360, Zoom: 6}
+
+	tests := []struct {
+		name string
+		in   Input
+	}{
+		{"negative tile zoom", Input{Tile: &geo.TileCoord{Z: -1}}},
+		{"tile zoom too deep", Input{Tile: &geo.TileCoord{Z: MaxZoom + 1}}},
+		{"tile outside the zoom level", Input{Tile: &geo.TileCoord{Z: 2, X: 4}}},
+		{"pixel outside the tile", Input{Tile: &geo.TileCoord{Z: 2, PX: DefaultTileSize}}},
+		{"nan game position", Input{X: ptr(math.NaN()), Z: ptr(1)}},
+		{"nan lon lat", Input{Lon: ptr(1), Lat: ptr(math.NaN())}},
+		{"lat beyond mercator", Input{Lon: ptr(0), Lat: ptr(89)}},
+		{"no coordinates", Input{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, err := conv.Convert(tt.in); err == nil {
+				t.Errorf("Convert() = %+v, want an error", p)
+			}
+		})
+	}
+
+	if _, err := (Converter{Size: 15360, Zoom: -1}).Convert(Input{X: ptr(1), Z: ptr(1)}); err == nil {
+		t.Error("Convert() with a negative converter zoom, want an error")
+	}
+}
+
+func TestConvertZoomZero(t *testing.T) {
+	conv := Converter{Size: 15360, Zoom: 0}
+
+	p, err := conv.Convert(Input{Tile: &geo.TileCoord{Z: 0, PX: 128, PY: 128}})
+	if err != nil {
+		t.Fatalf("Convert(): %v", err)
+	}
+	if p.X != 7710 || p.Z != 7650 {
+		t.Errorf("Convert() position = %g,%g, want 7710,7650", p.X, p.Z)
+	}
+	if p.Tile != (geo.TileCoord{Z: 0, PX: 128, PY: 128}) {
+		t.Errorf("Convert() tile = %+v", p.Tile)
+	}
+}
+
+func ptr(v float64) *float64 { return &v }
@@ -0,0 +1,177 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// leafletSize is the extent of the Leaflet CRS.Simple world used by the web viewer and xam.nu.
+const leafletSize = 256.0
+
+// gridRefSize is the side of an in-game map grid square in metres.
+const gridRefSize = 100.0
+
+// ErrInvalidGridRef is returned when a grid reference cannot be parsed.
+var ErrInvalidGridRef = errors.New("invalid grid reference")
+
+// TileCoord is a position on the Web Mercator tile grid: the tile and the pixel within it.
+type TileCoord struct {
+	Z  int `json:"z"`
+	X  int `json:"x"`
+	Y  int `json:"y"`
+	PX int `json:"px"`
+	PY int `json:"py"`
+}
+
+// GameToLeaflet converts Game Coordinates to the Leaflet CRS.Simple LatLng used by the web viewer.
+// The game world maps to lng 0..256 and lat -256..0.
+func GameToLeaflet(x, z, mapSize float64) (lat, lng float64) {
+	ratio := mapSize / leafletSize
+
+	return z/ratio - leafletSize, x / ratio
+}
+
+// LeafletToGame converts a Leaflet CRS.Simple LatLng back to Game Coordinates.
+func LeafletToGame(lat, lng, mapSize float64) (x, z float64) {
+	ratio := mapSize / leafletSize
+
+	return lng * ratio, (leafletSize + lat) * ratio
+}
+
+// XamToGame converts a xam.nu marker position [lat, lng] to Game Coordinates.
+// xam.nu uses the same CRS.Simple world as the web viewer.
+func XamToGame(lat, lng, mapSize float64) (x, z float64) {
+	return LeafletToGame(lat, lng, mapSize)
+}
+
+// GameToXam converts Game Coordinates to a xam.nu marker position [lat, lng].
+func GameToXam(x, z, mapSize float64) (lat, lng float64) {
+	return GameToLeaflet(x, z, mapSize)
+}
+
+// IzurviveToWGS84 converts iZurvive coordinates to WGS84.
+// iZurvive location data is already published in the projection of GameToMetricZ,
+// so the conversion only swaps the order to lon/lat.
+func IzurviveToWGS84(lat, lng float64) (lon, latOut float64) {
+	return lng, lat
+}
+
+// WGS84ToIzurvive converts WGS84 to iZurvive lat/lng.
+func WGS84ToIzurvive(lon, lat float64) (latOut, lng float64) {
+	return lat, lon
+}
+
+// IzurviveToGame converts iZurvive lat/lng to Game Coordinates.
+func IzurviveToGame(lat, lng, mapSize float64) (x, z float64) {
+	return MetricZToGame(lng, lat, mapSize)
+}
+
+// GameToIzurvive converts Game Coordinates to iZurvive lat/lng.
+func GameToIzurvive(x, z, mapSize float64) (lat, lng float64) {
+	lng, lat = GameToMetricZ(x, z, mapSize)
+
+	return lat, lng
+}
+
+// TileToLonLat converts a fractional position on the tile grid of zoom level z to WGS84.
+// It is the inverse of LonLatToTile.
+func TileToLonLat(x, y float64, z int) (lon, lat float64) {
+	n := float64(uint64(1) << z)
+
+	lon = x/n*360.0 - 180.0
+	lat = math.Atan(math.Sinh(math.Pi*(1.0-2.0*y/n))) * (180.0 / math.Pi)
+
+	return lon, lat
+}
+
+// GameToTile projects Game Coordinates onto the tile grid of zoom level z.
+// The game world covers the whole Web Mercator square, so the projection is linear:
+// game z = size is the top edge (tile y = 0).
+func GameToTile(x, z, mapSize float64, zoom int) (tx, ty float64) {
+	n := float64(uint64(1) << zoom)
+
+	return x / mapSize * n, (mapSize - z) / mapSize * n
+}
+
+// TileToGame converts a fractional tile grid position of zoom level z to Game Coordinates.
+func TileToGame(tx, ty, mapSize float64, zoom int) (x, z float64) {
+	n := float64(uint64(1) << zoom)
+
+	return tx / n * mapSize, mapSize - ty/n*mapSize
+}
+
+// GameToTileCoord returns the tile and pixel holding a game position at zoom level z
+// for tiles of tileSize pixels.
+func GameToTileCoord(x, z, mapSize float64, zoom, tileSize int) TileCoord {
+	tx, ty := GameToTile(x, z, mapSize, zoom)
+	last := float64(int(1)<<zoom) - 1e-9
+
+	// keep the far edges inside the last tile
+	tx = math.Max(0, math.Min(last, tx))
+	ty = math.Max(0, math.Min(last, ty))
+
+	return TileCoord{
+		Z:  zoom,
+		X:  int(tx),
+		Y:  int(ty),
+		PX: int((tx - math.Floor(tx)) * float64(tileSize)),
+		PY: int((ty - math.Floor(ty)) * float64(tileSize)),
+	}
+}
+
+// TileCoordToGame returns the game position of the centre of a tile pixel.
+func TileCoordToGame(t TileCoord, mapSize float64, tileSize int) (x, z float64) {
+	tx := float64(t.X) + (float64(t.PX)+0.5)/float64(tileSize)
+	ty := float64(t.Y) + (float64(t.PY)+0.5)/float64(tileSize)
+
+	return TileToGame(tx, ty, mapSize, t.Z)
+}
+
+// GameToGridRef returns the in-game map grid reference of a position, e.g. "045 123".
+// Columns count 100 m squares from the west edge, rows from the north edge.
+func GameToGridRef(x, z, mapSize float64) string {
+	col := int(math.Floor(math.Max(0, math.Min(mapSize, x)) / gridRefSize))
+	row := int(math.Floor(math.Max(0, math.Min(mapSize, mapSize-z)) / gridRefSize))
+
+	// the far edges belong to the last square
+	if last := int(math.Ceil(mapSize/gridRefSize)) - 1; last >= 0 {
+		col, row = min(col, last), min(row, last)
+	}
+
+	return fmt.Sprintf("%03d %03d", col, row)
+}
+
+// GridRefToGame returns the game position of the centre of a grid square.
+// Both "045 123" and "045123" forms are accepted.
+func GridRefToGame(ref string, mapSize float64) (x, z float64, err error) {
+	ref = strings.TrimSpace(ref)
+
+	var colStr, rowStr string
+	if fields := strings.Fields(ref); len(fields) == 2 {
+		colStr, rowStr = fields[0], fields[1]
+	} else if len(ref)%2 == 0 && len(ref) >= 6 {
+		colStr, rowStr = ref[:len(ref)/2], ref[len(ref)/2:]
+	} else {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidGridRef, ref)
+	}
+
+	col, errCol := strconv.Atoi(colStr)
+	row, errRow := strconv.Atoi(rowStr)
+	if errCol != nil || errRow != nil || col < 0 || row < 0 ||
+		float64(col)*gridRefSize >= mapSize || float64(row)*gridRefSize >= mapSize {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidGridRef, ref)
+	}
+
+	x = (float64(col) + 0.5) * gridRefSize
+	z = mapSize - (float64(row)+0.5)*gridRefSize
+
+	return x, z, nil
+}
+
+// ClampGame limits a position to the game world.
+func ClampGame(x, z, mapSize float64) (float64, float64) {
+	return math.Max(0, math.Min(mapSize, x)), math.Max(0, math.Min(mapSize, z))
+}
@@ -0,0 +1,273 @@
+package geo
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// testMapSizes are the sizes of the stock maps: Chernarus, Livonia and a small custom map.
+var testMapSizes = []float64{15360, 12800, 1024}
+
+// testPoints returns game positions covering the corners, edges and inside of a map.
+func testPoints(size float64) [][2]float64 {
+	return [][2]float64{
+		{0, 0},
+		{size, size},
+		{0, size},
+		{size, 0},
+		{size / 2, size / 2},
+		{size / 2, 0},
+		{size / 2, size},
+		{size * 0.293, size * 0.664},
+		{0.01, size - 0.01},
+	}
+}
+
+func assertNear(t *testing.T, name string, got, want, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-want) > tolerance {
+		t.Errorf("%s = %.9f, want %.9f", name, got, want)
+	}
+}
+
+func TestGameWGS84RoundTrip(t *testing.T) {
+	for _, size := range testMapSizes {
+		for _, p := range testPoints(size) {
+			lon, lat := GameToMetricZ(p[0], p[1], size)
+			if lon < -180 || lon > 180 || lat < -MaxLat || lat > MaxLat {
+				t.Errorf("size %g: %v gives lon/lat %g,%g outside the Mercator world", size, p, lon, lat)
+			}
+
+			x, z := MetricZToGame(lon, lat, size)
+			assertNear(t, "x", x, p[0], 1e-6)
+			assertNear(t, "z", z, p[1], 1e-6)
+		}
+	}
+}
+
+func TestGameToMetricZEdges(t *testing.T) {
+	tests := []struct {
+		name     string
+		x, z     float64
+		lon, lat float64
+	}{
+		{"south west corner", 0, 0, -180, -MaxLat},
+		{"north east corner", 15360, 15360, 180, MaxLat},
+		{"centre", 7680, 7680, 0, 0},
+		{"clamped north", 7680, 20000, 0, MaxLat},
+		{"clamped south", 7680, -5000, 0, -MaxLat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lon, lat := GameToMetricZ(tt.x, tt.z, 15360)
+			assertNear(t, "lon", lon, tt.lon, 1e-7)
+			assertNear(t, "lat", lat, tt.lat, 1e-7)
+			if math.Abs(lat) > MaxLat {
+				t.Errorf("lat %.10f exceeds the Mercator limit", lat)
+			}
+		})
+	}
+}
+
+func TestLeafletRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		x, z     float64
+		lat, lng float64
+	}{
+		{"south west corner", 0, 0, -256, 0},
+		{"north east corner", 15360, 15360, 0, 256},
+		{"north west corner", 0, 15360, 0, 0},
+		{"centre", 7680, 7680, -128, 128},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lng := GameToLeaflet(tt.x, tt.z, 15360)
+			assertNear(t, "lat", lat, tt.lat, 1e-9)
+			assertNear(t, "lng", lng, tt.lng, 1e-9)
+
+			x, z := LeafletToGame(lat, lng, 15360)
+			assertNear(t, "x", x, tt.x, 1e-9)
+			assertNear(t, "z", z, tt.z, 1e-9)
+		})
+	}
+
+	for _, size := range testMapSizes {
+		for _, p := range testPoints(size) {
+			lat, lng := GameToXam(p[0], p[1], size)
+			x, z := XamToGame(lat, lng, size)
+			assertNear(t, "xam x", x, p[0], 1e-9)
+			assertNear(t, "xam z", z, p[1], 1e-9)
+		}
+	}
+}
+
+func TestIzurviveRoundTrip(t *testing.T) {
+	for _, size := range testMapSizes {
+		for _, p := range testPoints(size) {
+			lat, lng := GameToIzurvive(p[0], p[1], size)
+
+			lon, latOut := IzurviveToWGS84(lat, lng)
+			wantLon, wantLat := GameToMetricZ(p[0], p[1], size)
+			assertNear(t, "lon", lon, wantLon, 1e-12)
+			assertNear(t, "lat", latOut, wantLat, 1e-12)
+
+			backLat, backLng := WGS84ToIzurvive(lon, latOut)
+			assertNear(t, "izurvive lat", backLat, lat, 1e-12)
+			assertNear(t, "izurvive lng", backLng, lng, 1e-12)
+
+			x, z := IzurviveToGame(lat, lng, size)
+			assertNear(t, "x", x, p[0], 1e-6)
+			assertNear(t, "z", z, p[1], 1e-6)
+		}
+	}
+}
+
+func TestTileRoundTrip(t *testing.T) {
+	for _, size := range testMapSizes {
+		for _, zoom := range []int{0, 3, 8} {
+			for _, p := range testPoints(size) {
+				tx, ty := GameToTile(p[0], p[1], size, zoom)
+				x, z := TileToGame(tx, ty, size, zoom)
+				assertNear(t, "x", x, p[0], 1e-6)
+				assertNear(t, "z", z, p[1], 1e-6)
+
+				// the linear game projection and the Web Mercator tile grid agree
+				lon, lat := GameToMetricZ(p[0], p[1], size)
+				mx, my := LonLatToTile(lon, lat, zoom)
+				assertNear(t, "mercator tile x", mx, tx, 1e-6)
+				assertNear(t, "mercator tile y", my, ty, 1e-6)
+
+				tileLon, tileLat := TileToLonLat(tx, ty, zoom)
+				assertNear(t, "lon", tileLon, lon, 1e-7)
+				assertNear(t, "lat", tileLat, lat, 1e-7)
+			}
+		}
+	}
+}
+
+func TestLonLatToTileClampsLatitude(t *testing.T) {
+	for _, lat := range []float64{MaxLat, 89, 90} {
+		if _, y := LonLatToTile(0, lat, 4); math.Abs(y) > 1e-6 {
+			t.Errorf("lat %g gives tile y %g, want 0", lat, y)
+		}
+		if _, y := LonLatToTile(0, -lat, 4); math.Abs(y-16) > 1e-6 {
+			t.Errorf("lat %g gives tile y %g, want 16", -lat, y)
+		}
+	}
+}
+
+func TestGameToTileCoord(t *testing.T) {
+	const size = 15360
+
+	tests := []struct {
+		name string
+		x, z float64
+		zoom int
+		want TileCoord
+	}{
+		{"north west corner", 0, size, 3, TileCoord{Z: 3, X: 0, Y: 0, PX: 0, PY: 0}},
+		{"south east corner", size, 0, 3, TileCoord{Z: 3, X: 7, Y: 7, PX: 255, PY: 255}},
+		{"centre", size / 2, size / 2, 1, TileCoord{Z: 1, X: 1, Y: 1, PX: 0, PY: 0}},
+		{"outside is clamped", -100, size + 100, 2, TileCoord{Z: 2, X: 0, Y: 0, PX: 0, PY: 0}},
+		{"zoom 0", size / 4, size / 4, 0, TileCoord{Z: 0, X: 0, Y: 0, PX: 64, PY: 192}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GameToTileCoord(tt.x, tt.z, size, tt.zoom, 256); got != tt.want {
+				t.Errorf("GameToTileCoord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// the centre of the pixel holding a point is at most half a pixel away
+	for _, zoom := range []int{0, 4, 7} {
+		pixel := size / float64(int(1)<<zoom) / 256
+		for _, p := range testPoints(size) {
+			x, z := TileCoordToGame(GameToTileCoord(p[0], p[1], size, zoom, 256), size, 256)
+			assertNear(t, "x", x, p[0], pixel/2+1e-6)
+			assertNear(t, "z", z, p[1], pixel/2+1e-6)
+		}
+	}
+}
+
+func TestGridRef(t *testing.T) {
+	const size = 15360
+
+	tests := []struct {
+		name string
+		x, z float64
+		want string
+	}{
+		{"north west corner", 0, size, "000 000"},
+		{"south west corner", 0, 0, "000 153"},
+		{"north east corner", size, size, "153 000"},
+		{"south east corner", size, 0, "153 153"},
+		{"inside", 4550, size - 12320, "045 123"},
+		{"outside is clamped", -50, size + 50, "000 000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GameToGridRef(tt.x, tt.z, size); got != tt.want {
+				t.Errorf("GameToGridRef() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, p := range testPoints(size) {
+		ref := GameToGridRef(p[0], p[1], size)
+		x, z, err := GridRefToGame(ref, size)
+		if err != nil {
+			t.Fatalf("GridRefToGame(%q): %v", ref, err)
+		}
+		// the centre of the square is at most half a square away on each axis
+		assertNear(t, "x", x, p[0], gridRefSize/2)
+		assertNear(t, "z", z, p[1], gridRefSize/2)
+		if back := GameToGridRef(x, z, size); back != ref {
+			t.Errorf("square centre of %q maps to %q", ref, back)
+		}
+	}
+}
+
+func TestGridRefToGame(t *testing.T) {
+	const size = 15360
+
+	tests := []struct {
+		ref  string
+		x, z float64
+		err  bool
+	}{
+		{ref: "045 123", x: 4550, z: size - 12350},
+		{ref: "045123", x: 4550, z: size - 12350},
+		{ref: " 000 000 ", x: 50, z: size - 50},
+		{ref: "153 153", x: 15350, z: 10},
+		{ref: "154 000", err: true},
+		{ref: "000 154", err: true},
+		{ref: "-01 000", err: true},
+		{ref: "04512", err: true},
+		{ref: "abc def", err: true},
+		{ref: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			x, z, err := GridRefToGame(tt.ref, size)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidGridRef) {
+					t.Errorf("GridRefToGame(%q) error = %v, want ErrInvalidGridRef", tt.ref, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GridRefToGame(%q): %v", tt.ref, err)
+			}
+			assertNear(t, "x", x, tt.x, 1e-9)
+			assertNear(t, "z", z, tt.z, 1e-9)
+		})
+	}
+}
@@ -2,6 +2,9 @@ package geo
 
 import "math"
 
+// MaxLat is the latitude limit of the Web Mercator projection in degrees.
+const MaxLat = 85.05112878
+
 // GameToMetricZ converts Game Coordinates (0..Size) to World WGS84 (Lon/Lat)
 // using a Mercator projection adapted for the game map size.
 //
@@ -19,7 +22,6 @@ func GameToMetricZ(x, z, mapSize float64) (lon, lat float64) {
 	// Inverse Mercator projection
 	latRad := (2.0 * math.Atan(math.Exp(mercatorY))) - (math.Pi * 0.5)
 
-	const MaxLat = 85.05112878
 	lat = latRad * (180.0 / math.Pi)
 
 	if lat > MaxLat {
@@ -30,3 +32,28 @@ func GameToMetricZ(x, z, mapSize float64) (lon, lat float64) {
 
 	return lon, lat
 }
+
+// MetricZToGame converts World WGS84 (Lon/Lat) back to Game Coordinates (0..Size).
+// It is the inverse of GameToMetricZ.
+func MetricZToGame(lon, lat, mapSize float64) (x, z float64) {
+	x = (lon + 180.0) * (mapSize / 360.0)
+
+	latRad := lat * (math.Pi / 180.0)
+	mercatorY := math.Log(math.Tan((math.Pi * 0.25) + (latRad * 0.5)))
+	z = (mercatorY + math.Pi) * (mapSize / (2.0 * math.Pi))
+
+	return x, z
+}
+
+// LonLatToTile projects WGS84 coordinates onto the Web Mercator tile grid of zoom level z.
+// The integer part of the result is the tile index, the fraction is the position within the tile.
+func LonLatToTile(lon, lat float64, z int) (x, y float64) {
+	lat = math.Max(-MaxLat, math.Min(MaxLat, lat))
+	n := float64(uint64(1) << z)
+	latRad := lat * (math.Pi / 180.0)
+
+	x = (lon + 180.0) / 360.0 * n
+	y = (1.0 - math.Log(math.Tan(latRad)+1.0/math.Cos(latRad))/math.Pi) / 2.0 * n
+
+	return x, y
+}
@@ -0,0 +1,307 @@
+// Package manifest computes and stores content hashes of the files of a map,
+// used for content-based ETags and versioned tile URLs.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileName is the name of the manifest in the map directory.
+const FileName = "manifest.json"
+
+// versionLength is the number of hex digits of the map version.
+const versionLength = 12
+
+// layerArchives are the single file forms of a layer, next to its tile directory.
+var layerArchives = []string{".pmtiles", ".zip", ".tar"}
+
+// Manifest holds the content hashes of the files of a map directory.
+type Manifest struct {
+	// Version is a short hash of all hashes below, it changes with any content
+	Version string `json:"version"`
+	// Layers holds the hash of the tiles of each layer by name
+	Layers map[string]string `json:"layers"`
+	// Locations is the hash of locations.geojson, empty without one
+	Locations string `json:"locations,omitempty"`
+	// Stamp is a hash of the names, sizes and modification times of the hashed
+	// files, it tells without reading them whether they changed, see Current
+	Stamp string `json:"stamp,omitempty"`
+}
+
+// mapFile is a file of a map directory covered by the manifest.
+type mapFile struct {
+	info fs.FileInfo
+	path string
+	name string // path relative to the layer, or the file name
+}
+
+// Build hashes the layers and the locations of the map directory dir.
+// Every tile directory and archive of a layer is hashed, layers without
+// any of them are left out.
+func Build(dir string, layers []string) (*Manifest, error) {
+	files, err := listFiles(dir, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	// stamp the files before reading them, changes made meanwhile outdate the manifest
+	m := &Manifest{
+		Layers: make(map[string]string, len(layers)),
+		Stamp:  stamp(files),
+	}
+
+	for _, layer := range layers {
+		layerFiles, ok := files[layer]
+		if !ok {
+			continue
+		}
+
+		h := sha256.New()
+		for _, f := range layerFiles {
+			if err := hashFile(h, f); err != nil {
+				return nil, err
+			}
+		}
+		m.Layers[layer] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if locations, ok := files[""]; ok {
+		h := sha256.New()
+		if err := hashFile(h, locations[0]); err != nil {
+			return nil, err
+		}
+		m.Locations = hex.EncodeToString(h.Sum(nil))
+	}
+
+	m.Version = m.version()
+	return m, nil
+}
+
+// version hashes the layer and locations hashes in a stable order.
+func (m *Manifest) version() string {
+	names := make([]string, 0, len(m.Layers))
+	for name := range m.Layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		_, _ = io.WriteString(h, name+"="+m.Layers[name]+"\n")
+	}
+	_, _ = io.WriteString(h, "locations="+m.Locations+"\n")
+
+	return hex.EncodeToString(h.Sum(nil))[:versionLength]
+}
+
+// Covers reports whether the manifest has hashes of exactly the given layers,
+// a manifest written before layers were added or removed is outdated.
+func (m *Manifest) Covers(layers []string) bool {
+	if len(m.Layers) != len(layers) {
+		return false
+	}
+	for _, name := range layers {
+		if _, ok := m.Layers[name]; !ok {
+			return false
+		}
+	}
+
+	return m.Version == m.version()
+}
+
+// Current reports whether the manifest covers the layers and the files of the
+// map directory dir are unchanged since it was built. Only file metadata is read.
+func (m *Manifest) Current(dir string, layers []string) (bool, error) {
+	if m.Stamp == "" || !m.Covers(layers) {
+		return false, nil
+	}
+
+	files, err := listFiles(dir, layers)
+	if err != nil {
+		return false, err
+	}
+
+	return stamp(files) == m.Stamp, nil
+}
+
+// Read reads the manifest of the map directory dir.
+// It returns fs.ErrNotExist if there is none.
+func Read(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Write stores the manifest in the map directory dir, replacing the previous one atomically.
+func Write(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, FileName+".*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, filepath.Join(dir, FileName))
+}
+
+// listFiles returns the files of each layer found in dir in hashing order:
+// the archives, then the tile tree. Layers without any of them are left out,
+// locations.geojson is listed under the empty name.
+func listFiles(dir string, layers []string) (map[string][]mapFile, error) {
+	files := make(map[string][]mapFile, len(layers)+1)
+
+	for _, layer := range layers {
+		var layerFiles []mapFile
+		found := false
+
+		for _, ext := range layerArchives {
+			f, ok, err := statFile(filepath.Join(dir, layer+ext), layer+ext)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				layerFiles = append(layerFiles, f)
+				found = true
+			}
+		}
+
+		tree, ok, err := listTree(filepath.Join(dir, layer))
+		if err != nil {
+			return nil, err
+		}
+		if found = found || ok; found {
+			files[layer] = append(layerFiles, tree...)
+		}
+	}
+
+	f, ok, err := statFile(filepath.Join(dir, "locations.geojson"), "locations.geojson")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		files[""] = []mapFile{f}
+	}
+
+	return files, nil
+}
+
+// listTree returns the regular files under root in lexical order,
+// reporting whether root is a directory.
+func listTree(root string) ([]mapFile, bool, error) {
+	info, err := os.Stat(root)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var files []mapFile
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, mapFile{info: info, path: path, name: filepath.ToSlash(rel)})
+		return nil
+	})
+
+	return files, true, err
+}
+
+// statFile returns the regular file at path, reporting whether it exists.
+func statFile(path, name string) (mapFile, bool, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return mapFile{}, false, nil
+	}
+	if err != nil {
+		return mapFile{}, false, err
+	}
+
+	return mapFile{info: info, path: path, name: name}, true, nil
+}
+
+// stamp hashes the names, sizes and modification times of the files.
+func stamp(files map[string][]mapFile) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	var buf [16]byte
+	for _, name := range names {
+		_, _ = io.WriteString(h, name+"\x00")
+		for _, f := range files[name] {
+			binary.BigEndian.PutUint64(buf[:8], uint64(f.info.Size()))
+			binary.BigEndian.PutUint64(buf[8:], uint64(f.info.ModTime().UnixNano()))
+			_, _ = io.WriteString(h, f.name+"\x00")
+			_, _ = h.Write(buf[:])
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile writes the name, size and content of the file to h.
+func hashFile(h hash.Hash, f mapFile) error {
+	r, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(f.info.Size()))
+
+	_, _ = io.WriteString(h, f.name+"\x00")
+	_, _ = h.Write(size[:])
+	_, err = io.Copy(h, r)
+
+	return err
+}
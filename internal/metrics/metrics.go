@@ -0,0 +1,257 @@
+// Package metrics implements a minimal set of Prometheus collectors
+// exposed in the OpenMetrics text format.
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the OpenMetrics text exposition format.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds suited for serving files from disk or memory.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Default is the registry collectors are added to by the constructors.
+var Default = &Registry{}
+
+// collector writes its metric family to the exposition.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry is an ordered set of collectors.
+type Registry struct {
+	collectors []collector
+	mu         sync.Mutex
+}
+
+// Handler returns an HTTP handler serving the registry in OpenMetrics format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+
+		bw := bufio.NewWriter(w)
+		r.mu.Lock()
+		for _, c := range r.collectors {
+			c.write(bw)
+		}
+		r.mu.Unlock()
+		_, _ = bw.WriteString("# EOF\n")
+		_ = bw.Flush()
+	})
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// Gauge is a single value that can go up and down.
+type Gauge struct {
+	name string
+	help string
+	bits atomic.Uint64
+}
+
+// NewGauge creates a gauge registered in the Default registry.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	Default.register(g)
+	return g
+}
+
+// Set sets the gauge value.
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeMeta(w, g.name, "gauge", g.help)
+	writeSample(w, g.name, nil, nil, math.Float64frombits(g.bits.Load()))
+}
+
+// CounterVec is a family of monotonic counters partitioned by labels.
+type CounterVec struct {
+	values map[string]*counterValue
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec creates a counter family registered in the Default registry.
+// The name must not carry the _total suffix, it is appended on exposition.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	Default.register(c)
+	return c
+}
+
+// Inc increments the counter with the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter with the given label values by v.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeMeta(w, c.name, "counter", c.help)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		cv := c.values[key]
+		writeSample(w, c.name+"_total", c.labels, cv.labels, cv.value)
+	}
+}
+
+// HistogramVec is a family of histograms partitioned by labels.
+type HistogramVec struct {
+	values  map[string]*histogramValue
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates a histogram family registered in the Default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe adds a single observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hv
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.sum += v
+	hv.count++
+	h.mu.Unlock()
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeMeta(w, h.name, "histogram", h.help)
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		hv := h.values[key]
+		values := append(append([]string(nil), hv.labels...), "")
+
+		for i, upper := range h.buckets {
+			values[len(values)-1] = formatFloat(upper)
+			writeSample(w, h.name+"_bucket", bucketLabels, values, float64(hv.counts[i]))
+		}
+		values[len(values)-1] = "+Inf"
+		writeSample(w, h.name+"_bucket", bucketLabels, values, float64(hv.count))
+		writeSample(w, h.name+"_sum", h.labels, hv.labels, hv.sum)
+		writeSample(w, h.name+"_count", h.labels, hv.labels, float64(hv.count))
+	}
+}
+
+func writeMeta(w *bufio.Writer, name, typ, help string) {
+	_, _ = w.WriteString("# TYPE " + name + " " + typ + "\n")
+	_, _ = w.WriteString("# HELP " + name + " " + escape(help) + "\n")
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, v float64) {
+	_, _ = w.WriteString(name)
+	if len(labels) > 0 {
+		_ = w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = w.WriteString(l + `="` + escape(values[i]) + `"`)
+		}
+		_ = w.WriteByte('}')
+	}
+	_, _ = w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,198 @@
+// Package mvt encodes Mapbox Vector Tiles (specification 2.1) with point features.
+//
+// See https://github.com/mapbox/vector-tile-spec/tree/master/2.1
+package mvt
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// DefaultExtent is the number of units along one tile edge.
+const DefaultExtent = 4096
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wire64Bit  = 1
+	wireBytes  = 2
+)
+
+// geometry commands and types
+const (
+	cmdMoveTo     = 1
+	geomTypePoint = 1
+	layerVersion  = 2
+)
+
+// Feature is a point feature in tile coordinates (0..Extent, origin top-left).
+type Feature struct {
+	Properties map[string]any
+	ID         uint64
+	X, Y       int32
+}
+
+// Layer is a named set of features sharing the same coordinate extent.
+type Layer struct {
+	Name     string
+	Features []Feature
+	Extent   uint32
+}
+
+// Encode serializes layers into a vector tile.
+// Layers without features are omitted.
+func Encode(layers ...Layer) []byte {
+	var tile []byte
+	for _, l := range layers {
+		if len(l.Features) == 0 {
+			continue
+		}
+		// Tile.layers = 3
+		tile = appendBytesField(tile, 3, l.marshal())
+	}
+
+	return tile
+}
+
+// marshal serializes a single layer message.
+// Field numbers follow vector_tile.proto of the specification.
+func (l Layer) marshal() []byte {
+	extent := l.Extent
+	if extent == 0 {
+		extent = DefaultExtent
+	}
+
+	var keys []string
+	keyIndex := make(map[string]uint32)
+	var values [][]byte
+	valueIndex := make(map[string]uint32)
+
+	var buf []byte
+	buf = appendVarintField(buf, 15, layerVersion)
+	buf = appendBytesField(buf, 1, []byte(l.Name))
+
+	for _, f := range l.Features {
+		// sorted keys keep the encoding stable between requests
+		names := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		var tags []uint64
+		for _, k := range names {
+			encoded, ok := encodeValue(f.Properties[k])
+			if !ok {
+				continue
+			}
+
+			ki, ok := keyIndex[k]
+			if !ok {
+				ki = uint32(len(keys))
+				keyIndex[k] = ki
+				keys = append(keys, k)
+			}
+
+			vi, ok := valueIndex[string(encoded)]
+			if !ok {
+				vi = uint32(len(values))
+				valueIndex[string(encoded)] = vi
+				values = append(values, encoded)
+			}
+
+			tags = append(tags, uint64(ki), uint64(vi))
+		}
+
+		var feat []byte
+		if f.ID != 0 {
+			feat = appendVarintField(feat, 1, f.ID)
+		}
+		if len(tags) > 0 {
+			feat = appendBytesField(feat, 2, packVarints(tags))
+		}
+		feat = appendVarintField(feat, 3, geomTypePoint)
+		feat = appendBytesField(feat, 4, packVarints([]uint64{
+			commandInteger(cmdMoveTo, 1),
+			zigzag(f.X),
+			zigzag(f.Y),
+		}))
+
+		buf = appendBytesField(buf, 2, feat)
+	}
+
+	for _, k := range keys {
+		buf = appendBytesField(buf, 3, []byte(k))
+	}
+	for _, v := range values {
+		buf = appendBytesField(buf, 4, v)
+	}
+	buf = appendVarintField(buf, 5, uint64(extent))
+
+	return buf
+}
+
+// encodeValue serializes a property into a Value message.
+// The second result is false for unsupported types.
+func encodeValue(v any) ([]byte, bool) {
+	switch val := v.(type) {
+	case string:
+		return appendBytesField(nil, 1, []byte(val)), true
+	case float64:
+		b := appendKey(nil, 3, wire64Bit)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(val)), true
+	case float32:
+		b := appendKey(nil, 3, wire64Bit)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(float64(val))), true
+	case int:
+		return appendVarintField(nil, 6, zigzag64(int64(val))), true
+	case int64:
+		return appendVarintField(nil, 6, zigzag64(val)), true
+	case uint64:
+		return appendVarintField(nil, 5, val), true
+	case bool:
+		var n uint64
+		if val {
+			n = 1
+		}
+		return appendVarintField(nil, 7, n), true
+	default:
+		return nil, false
+	}
+}
+
+func commandInteger(id, count uint32) uint64 {
+	return uint64((id & 0x7) | (count << 3))
+}
+
+func zigzag(n int32) uint64 {
+	return uint64(uint32((n << 1) ^ (n >> 31)))
+}
+
+func zigzag64(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func appendKey(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendKey(b, field, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func packVarints(vs []uint64) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.AppendUvarint(b, v)
+	}
+
+	return b
+}
@@ -0,0 +1,158 @@
+package pmtiles
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Entry is a single record of a PMTiles directory.
+// A RunLength of zero marks a pointer to a leaf directory.
+type Entry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// serializeEntries encodes a directory and compresses it with the given compression.
+func serializeEntries(entries []Entry, compression uint8) ([]byte, error) {
+	var raw bytes.Buffer
+	tmp := make([]byte, binary.MaxVarintLen64)
+
+	putVarint := func(v uint64) {
+		n := binary.PutUvarint(tmp, v)
+		raw.Write(tmp[:n])
+	}
+
+	putVarint(uint64(len(entries)))
+
+	var lastID uint64
+	for _, e := range entries {
+		putVarint(e.TileID - lastID)
+		lastID = e.TileID
+	}
+	for _, e := range entries {
+		putVarint(uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		putVarint(uint64(e.Length))
+	}
+	for i, e := range entries {
+		if i > 0 && e.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			putVarint(0)
+		} else {
+			putVarint(e.Offset + 1)
+		}
+	}
+
+	return compress(raw.Bytes(), compression)
+}
+
+// deserializeEntries decompresses and decodes a directory.
+func deserializeEntries(data []byte, compression uint8) ([]Entry, error) {
+	raw, err := decompress(data, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(raw))
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("pmtiles: read directory size: %w", err)
+	}
+
+	entries := make([]Entry, count)
+
+	var lastID uint64
+	for i := range entries {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("pmtiles: read tile id: %w", err)
+		}
+		lastID += v
+		entries[i].TileID = lastID
+	}
+	for i := range entries {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("pmtiles: read run length: %w", err)
+		}
+		entries[i].RunLength = uint32(v)
+	}
+	for i := range entries {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("pmtiles: read length: %w", err)
+		}
+		entries[i].Length = uint32(v)
+	}
+	for i := range entries {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("pmtiles: read offset: %w", err)
+		}
+		if v == 0 && i > 0 {
+			entries[i].Offset = entries[i-1].Offset + uint64(entries[i-1].Length)
+		} else {
+			entries[i].Offset = v - 1
+		}
+	}
+
+	return entries, nil
+}
+
+// findEntry returns the entry covering the tile ID, which may be a leaf pointer.
+func findEntry(entries []Entry, id uint64) (Entry, bool) {
+	// index of the first entry with TileID > id
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].TileID > id })
+	if i == 0 {
+		return Entry{}, false
+	}
+
+	e := entries[i-1]
+	if e.RunLength == 0 || id < e.TileID+uint64(e.RunLength) {
+		return e, true
+	}
+
+	return Entry{}, false
+}
+
+func compress(data []byte, compression uint8) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+}
+
+func decompress(data []byte, compression uint8) ([]byte, error) {
+	switch compression {
+	case CompressionNone, CompressionUnknown:
+		return data, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = zr.Close() }()
+		return io.ReadAll(zr)
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+}
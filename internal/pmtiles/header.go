@@ -0,0 +1,138 @@
+// Package pmtiles implements reading and writing of PMTiles v3 archives.
+//
+// See https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md
+package pmtiles
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// HeaderLength is the fixed size of a serialized v3 header.
+const HeaderLength = 127
+
+// Compression types used for directories, metadata and tiles.
+const (
+	CompressionUnknown uint8 = 0
+	CompressionNone    uint8 = 1
+	CompressionGzip    uint8 = 2
+	CompressionBrotli  uint8 = 3
+	CompressionZstd    uint8 = 4
+)
+
+// Tile types stored in the archive.
+const (
+	TileTypeUnknown uint8 = 0
+	TileTypeMVT     uint8 = 1
+	TileTypePNG     uint8 = 2
+	TileTypeJPEG    uint8 = 3
+	TileTypeWebP    uint8 = 4
+	TileTypeAVIF    uint8 = 5
+)
+
+var (
+	// ErrInvalidHeader is returned when the archive does not start with a valid v3 header.
+	ErrInvalidHeader = errors.New("pmtiles: invalid header")
+	// ErrUnsupportedCompression is returned for compression types this package cannot decode.
+	ErrUnsupportedCompression = errors.New("pmtiles: unsupported compression")
+)
+
+// Header is the fixed-size header at the start of every PMTiles v3 archive.
+type Header struct {
+	RootOffset          uint64
+	RootLength          uint64
+	MetadataOffset      uint64
+	MetadataLength      uint64
+	LeafDirsOffset      uint64
+	LeafDirsLength      uint64
+	TileDataOffset      uint64
+	TileDataLength      uint64
+	AddressedTiles      uint64
+	TileEntries         uint64
+	TileContents        uint64
+	MinLonE7            int32
+	MinLatE7            int32
+	MaxLonE7            int32
+	MaxLatE7            int32
+	CenterLonE7         int32
+	CenterLatE7         int32
+	Clustered           bool
+	InternalCompression uint8
+	TileCompression     uint8
+	TileType            uint8
+	MinZoom             uint8
+	MaxZoom             uint8
+	CenterZoom          uint8
+}
+
+// MarshalBinary serializes the header into its 127 byte representation.
+func (h Header) MarshalBinary() ([]byte, error) {
+	b := make([]byte, HeaderLength)
+	copy(b[0:7], "PMTiles")
+	b[7] = 3
+
+	le := binary.LittleEndian
+	le.PutUint64(b[8:], h.RootOffset)
+	le.PutUint64(b[16:], h.RootLength)
+	le.PutUint64(b[24:], h.MetadataOffset)
+	le.PutUint64(b[32:], h.MetadataLength)
+	le.PutUint64(b[40:], h.LeafDirsOffset)
+	le.PutUint64(b[48:], h.LeafDirsLength)
+	le.PutUint64(b[56:], h.TileDataOffset)
+	le.PutUint64(b[64:], h.TileDataLength)
+	le.PutUint64(b[72:], h.AddressedTiles)
+	le.PutUint64(b[80:], h.TileEntries)
+	le.PutUint64(b[88:], h.TileContents)
+	if h.Clustered {
+		b[96] = 1
+	}
+	b[97] = h.InternalCompression
+	b[98] = h.TileCompression
+	b[99] = h.TileType
+	b[100] = h.MinZoom
+	b[101] = h.MaxZoom
+	le.PutUint32(b[102:], uint32(h.MinLonE7))
+	le.PutUint32(b[106:], uint32(h.MinLatE7))
+	le.PutUint32(b[110:], uint32(h.MaxLonE7))
+	le.PutUint32(b[114:], uint32(h.MaxLatE7))
+	b[118] = h.CenterZoom
+	le.PutUint32(b[119:], uint32(h.CenterLonE7))
+	le.PutUint32(b[123:], uint32(h.CenterLatE7))
+
+	return b, nil
+}
+
+// UnmarshalBinary parses a serialized header.
+func (h *Header) UnmarshalBinary(b []byte) error {
+	if len(b) < HeaderLength || string(b[0:7]) != "PMTiles" || b[7] != 3 {
+		return ErrInvalidHeader
+	}
+
+	le := binary.LittleEndian
+	h.RootOffset = le.Uint64(b[8:])
+	h.RootLength = le.Uint64(b[16:])
+	h.MetadataOffset = le.Uint64(b[24:])
+	h.MetadataLength = le.Uint64(b[32:])
+	h.LeafDirsOffset = le.Uint64(b[40:])
+	h.LeafDirsLength = le.Uint64(b[48:])
+	h.TileDataOffset = le.Uint64(b[56:])
+	h.TileDataLength = le.Uint64(b[64:])
+	h.AddressedTiles = le.Uint64(b[72:])
+	h.TileEntries = le.Uint64(b[80:])
+	h.TileContents = le.Uint64(b[88:])
+	h.Clustered = b[96] == 1
+	h.InternalCompression = b[97]
+	h.TileCompression = b[98]
+	h.TileType = b[99]
+	h.MinZoom = b[100]
+	h.MaxZoom = b[101]
+	h.MinLonE7 = int32(le.Uint32(b[102:]))
+	h.MinLatE7 = int32(le.Uint32(b[106:]))
+	h.MaxLonE7 = int32(le.Uint32(b[110:]))
+	h.MaxLatE7 = int32(le.Uint32(b[114:]))
+	h.CenterZoom = b[118]
+	h.CenterLonE7 = int32(le.Uint32(b[119:]))
+	h.CenterLatE7 = int32(le.Uint32(b[123:]))
+
+	return nil
+}
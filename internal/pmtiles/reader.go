@@ -0,0 +1,150 @@
+package pmtiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxDepth limits the number of leaf directory hops when looking up a tile.
+const maxDepth = 4
+
+// Reader provides random access to tiles of a PMTiles archive.
+// It is safe for concurrent use.
+type Reader struct {
+	r      io.ReaderAt
+	closer io.Closer
+	leaves map[uint64][]Entry
+	root   []Entry
+	Header Header
+	mu     sync.RWMutex
+}
+
+// Open opens a PMTiles archive from disk.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err := NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rd.closer = f
+
+	return rd, nil
+}
+
+// NewReader reads the header and root directory from r.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	buf := make([]byte, HeaderLength)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+
+	rd := &Reader{r: r, leaves: make(map[uint64][]Entry)}
+	if err := rd.Header.UnmarshalBinary(buf); err != nil {
+		return nil, err
+	}
+
+	root, err := rd.readDirectory(rd.Header.RootOffset, rd.Header.RootLength)
+	if err != nil {
+		return nil, err
+	}
+	rd.root = root
+
+	return rd, nil
+}
+
+// Tile returns the tile data, or ok=false if the archive does not contain the tile.
+func (rd *Reader) Tile(z uint8, x, y uint32) (data []byte, ok bool, err error) {
+	if z < rd.Header.MinZoom || z > rd.Header.MaxZoom {
+		return nil, false, nil
+	}
+	if n := uint32(1) << z; x >= n || y >= n {
+		return nil, false, nil
+	}
+
+	id := ZxyToID(z, x, y)
+	dir := rd.root
+
+	for depth := 0; depth < maxDepth; depth++ {
+		e, found := findEntry(dir, id)
+		if !found {
+			return nil, false, nil
+		}
+
+		if e.RunLength > 0 {
+			data = make([]byte, e.Length)
+			if _, err := rd.r.ReadAt(data, int64(rd.Header.TileDataOffset+e.Offset)); err != nil {
+				return nil, false, err
+			}
+			return data, true, nil
+		}
+
+		dir, err = rd.leaf(e.Offset, uint64(e.Length))
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, fmt.Errorf("pmtiles: directory depth exceeded for tile %d/%d/%d", z, x, y)
+}
+
+// Metadata decodes the JSON metadata section into v.
+func (rd *Reader) Metadata(v any) error {
+	buf := make([]byte, rd.Header.MetadataLength)
+	if _, err := rd.r.ReadAt(buf, int64(rd.Header.MetadataOffset)); err != nil {
+		return err
+	}
+
+	raw, err := decompress(buf, rd.Header.InternalCompression)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// Close closes the underlying file if the reader was created with Open.
+func (rd *Reader) Close() error {
+	if rd.closer == nil {
+		return nil
+	}
+
+	return rd.closer.Close()
+}
+
+// leaf returns a cached leaf directory, reading it on first access.
+func (rd *Reader) leaf(offset, length uint64) ([]Entry, error) {
+	rd.mu.RLock()
+	entries, ok := rd.leaves[offset]
+	rd.mu.RUnlock()
+	if ok {
+		return entries, nil
+	}
+
+	entries, err := rd.readDirectory(rd.Header.LeafDirsOffset+offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	rd.mu.Lock()
+	rd.leaves[offset] = entries
+	rd.mu.Unlock()
+
+	return entries, nil
+}
+
+func (rd *Reader) readDirectory(offset, length uint64) ([]Entry, error) {
+	buf := make([]byte, length)
+	if _, err := rd.r.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+
+	return deserializeEntries(buf, rd.Header.InternalCompression)
+}
@@ -0,0 +1,189 @@
+package pmtiles
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxRootSize is the largest root directory that fits into the first 16 KiB together with the header.
+const maxRootSize = 16384 - HeaderLength
+
+// Writer builds a PMTiles archive from tiles added in ascending tile ID order.
+// Tile data is spooled to a temporary file and identical tiles are stored once.
+type Writer struct {
+	tmp      *os.File
+	hashes   map[[sha256.Size]byte]Entry
+	entries  []Entry
+	offset   uint64
+	tiles    uint64
+	lastID   uint64
+	minZoom  uint8
+	maxZoom  uint8
+	hasTiles bool
+}
+
+// NewWriter creates a writer spooling tile data into a temporary file in dir.
+func NewWriter(dir string) (*Writer, error) {
+	tmp, err := os.CreateTemp(dir, ".pmtiles-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		tmp:    tmp,
+		hashes: make(map[[sha256.Size]byte]Entry),
+	}, nil
+}
+
+// WriteTile adds a tile to the archive. IDs must be strictly ascending.
+func (w *Writer) WriteTile(id uint64, data []byte) error {
+	if w.hasTiles && id <= w.lastID {
+		return fmt.Errorf("pmtiles: tile id %d is not ascending (last %d)", id, w.lastID)
+	}
+
+	z, _, _ := IDToZxy(id)
+	if !w.hasTiles || z < w.minZoom {
+		w.minZoom = z
+	}
+	if !w.hasTiles || z > w.maxZoom {
+		w.maxZoom = z
+	}
+	w.hasTiles = true
+	w.lastID = id
+	w.tiles++
+
+	sum := sha256.Sum256(data)
+	if stored, ok := w.hashes[sum]; ok {
+		// extend the run if the previous entry points to the same content
+		if n := len(w.entries); n > 0 {
+			last := &w.entries[n-1]
+			if last.Offset == stored.Offset && last.TileID+uint64(last.RunLength) == id {
+				last.RunLength++
+				return nil
+			}
+		}
+
+		w.entries = append(w.entries, Entry{TileID: id, Offset: stored.Offset, Length: stored.Length, RunLength: 1})
+		return nil
+	}
+
+	if _, err := w.tmp.Write(data); err != nil {
+		return err
+	}
+
+	e := Entry{TileID: id, Offset: w.offset, Length: uint32(len(data)), RunLength: 1}
+	w.hashes[sum] = e
+	w.entries = append(w.entries, e)
+	w.offset += uint64(len(data))
+
+	return nil
+}
+
+// Finalize writes the complete archive to out.
+// Zoom range, offsets and counters in h are filled in by the writer,
+// bounds, center and tile type must be set by the caller.
+func (w *Writer) Finalize(out io.Writer, h Header, metadata any) error {
+	root, leaves, err := buildDirectories(w.entries, CompressionGzip)
+	if err != nil {
+		return err
+	}
+
+	metaJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	meta, err := compress(metaJSON, CompressionGzip)
+	if err != nil {
+		return err
+	}
+
+	h.Clustered = true
+	h.InternalCompression = CompressionGzip
+	if h.TileCompression == CompressionUnknown {
+		h.TileCompression = CompressionNone
+	}
+	h.MinZoom = w.minZoom
+	h.MaxZoom = w.maxZoom
+	if h.CenterZoom < h.MinZoom || h.CenterZoom > h.MaxZoom {
+		h.CenterZoom = h.MinZoom
+	}
+
+	h.RootOffset = HeaderLength
+	h.RootLength = uint64(len(root))
+	h.MetadataOffset = h.RootOffset + h.RootLength
+	h.MetadataLength = uint64(len(meta))
+	h.LeafDirsOffset = h.MetadataOffset + h.MetadataLength
+	h.LeafDirsLength = uint64(len(leaves))
+	h.TileDataOffset = h.LeafDirsOffset + h.LeafDirsLength
+	h.TileDataLength = w.offset
+	h.AddressedTiles = w.tiles
+	h.TileEntries = uint64(len(w.entries))
+	h.TileContents = uint64(len(w.hashes))
+
+	headerBytes, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range [][]byte{headerBytes, root, meta, leaves} {
+		if _, err := out.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(out, w.tmp)
+
+	return err
+}
+
+// Close removes the temporary tile data file.
+func (w *Writer) Close() error {
+	name := w.tmp.Name()
+	closeErr := w.tmp.Close()
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// buildDirectories serializes the entries into a root directory,
+// splitting them into leaf directories when the root would not fit.
+func buildDirectories(entries []Entry, compression uint8) (root, leaves []byte, err error) {
+	root, err = serializeEntries(entries, compression)
+	if err != nil || len(root) <= maxRootSize {
+		return root, nil, err
+	}
+
+	for leafSize := 4096; ; leafSize *= 2 {
+		var rootEntries []Entry
+		leaves = leaves[:0]
+
+		for start := 0; start < len(entries); start += leafSize {
+			end := min(start+leafSize, len(entries))
+
+			leaf, err := serializeEntries(entries[start:end], compression)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			rootEntries = append(rootEntries, Entry{
+				TileID: entries[start].TileID,
+				Offset: uint64(len(leaves)),
+				Length: uint32(len(leaf)),
+			})
+			leaves = append(leaves, leaf...)
+		}
+
+		root, err = serializeEntries(rootEntries, compression)
+		if err != nil || len(root) <= maxRootSize {
+			return root, leaves, err
+		}
+	}
+}
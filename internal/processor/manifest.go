@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"path/filepath"
+
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/manifest"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WriteManifest hashes the tiles and locations of the map into maps/{name}/manifest.json,
+// the server reads the map version from it instead of hashing the files itself.
+func WriteManifest(m config.Map) error {
+	dir := filepath.Join("maps", m.Name)
+
+	layers := make([]string, 0, len(m.Layers))
+	for _, l := range m.Layers {
+		layers = append(layers, l.Name)
+	}
+
+	mf, err := manifest.Build(dir, layers)
+	if err != nil {
+		return err
+	}
+	if err := manifest.Write(dir, mf); err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("map", m.Name).
+		Str("version", mf.Version).
+		Int("layers", len(mf.Layers)).
+		Msg("Manifest written")
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package processor
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/geo"
+	"github.com/woozymasta/dzmap/internal/pmtiles"
+
+	"github.com/chai2010/webp"
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 database/sql driver
+	"github.com/rs/zerolog/log"
+)
+
+const mbtilesSchema = `
+CREATE TABLE metadata (name TEXT, value TEXT);
+CREATE UNIQUE INDEX name ON metadata (name);
+CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB);
+CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row);
+`
+
+// ExportMBTiles packs the maps/{name}/{layer} tile pyramid into an MBTiles file at outPath.
+// An existing file is only replaced when force is set.
+func ExportMBTiles(m config.Map, layer, outPath string, force bool) (int, error) {
+	baseDir := filepath.Join("maps", m.Name, layer)
+
+	tiles, err := collectTiles(baseDir)
+	if err != nil {
+		return 0, err
+	}
+	if len(tiles) == 0 {
+		return 0, fmt.Errorf("no tiles found in %s", baseDir)
+	}
+
+	if _, err := os.Stat(outPath); err == nil {
+		if !force {
+			return 0, fmt.Errorf("%s already exists", outPath)
+		}
+		if err := os.Remove(outPath); err != nil {
+			return 0, err
+		}
+	}
+
+	db, err := sql.Open("sqlite3", outPath)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = db.Close() }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(mbtilesSchema); err != nil {
+		return 0, fmt.Errorf("create schema: %w", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	var minZoom, maxZoom uint8 = 255, 0
+	for _, t := range tiles {
+		data, err := os.ReadFile(t.Path)
+		if err != nil {
+			return 0, err
+		}
+
+		z, x, y := pmtiles.IDToZxy(t.ID)
+		minZoom = min(minZoom, z)
+		maxZoom = max(maxZoom, z)
+
+		// MBTiles uses TMS row numbering with the origin at the bottom
+		row := (uint32(1) << z) - 1 - y
+		if _, err := stmt.Exec(z, x, row, data); err != nil {
+			return 0, err
+		}
+	}
+
+	metadata := map[string]string{
+		"name":        m.Name + " " + layer,
+		"description": fmt.Sprintf("DayZ %s %s tiles", m.Name, layer),
+		"format":      "webp",
+		"type":        "baselayer",
+		"version":     "1",
+		"bounds":      "-180,-85.0511287,180,85.0511287",
+		"center":      "0,0," + strconv.Itoa(int(minZoom)),
+		"minzoom":     strconv.Itoa(int(minZoom)),
+		"maxzoom":     strconv.Itoa(int(maxZoom)),
+	}
+	// the map covers the square from game origin to its size, as in TileJSON
+	if m.Size > 0 {
+		size := float64(m.Size)
+		minLon, minLat := geo.GameToMetricZ(0, 0, size)
+		maxLon, maxLat := geo.GameToMetricZ(size, size, size)
+		centerLon, centerLat := geo.GameToMetricZ(size/2, size/2, size)
+
+		metadata["bounds"] = joinFloats(minLon, minLat, maxLon, maxLat)
+		metadata["center"] = joinFloats(centerLon, centerLat) + "," + strconv.Itoa(int(minZoom))
+	}
+
+	attribution := m.Attribution
+	if l := m.Layer(layer); l != nil {
+		if l.Overlay {
+			metadata["type"] = "overlay"
+		}
+		if l.Attribution != "" {
+			attribution = l.Attribution
+		}
+	}
+	if attribution != "" {
+		metadata["attribution"] = attribution
+	}
+
+	for name, value := range metadata {
+		if _, err := tx.Exec("INSERT INTO metadata (name, value) VALUES (?, ?)", name, value); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(tiles), tx.Commit()
+}
+
+// ImportMBTiles unpacks an MBTiles file into the maps/{name}/{layer} tile pyramid.
+// Tiles in formats other than WebP are converted. Existing tiles are kept unless force is set.
+func ImportMBTiles(m config.Map, layer, inPath string, force bool) (int, error) {
+	if _, err := os.Stat(inPath); err != nil {
+		return 0, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+inPath+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = db.Close() }()
+
+	var format string
+	err = db.QueryRow("SELECT value FROM metadata WHERE name = 'format'").Scan(&format)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("read metadata: %w", err)
+	}
+	if format == "pbf" {
+		return 0, fmt.Errorf("vector MBTiles are not supported")
+	}
+
+	rows, err := db.Query("SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	baseDir := filepath.Join("maps", m.Name, layer)
+	count := 0
+
+	for rows.Next() {
+		var z, x, row int
+		var data []byte
+		if err := rows.Scan(&z, &x, &row, &data); err != nil {
+			return count, err
+		}
+		if z < 0 || z > 30 || len(data) == 0 {
+			continue
+		}
+
+		// reject coordinates outside of the zoom level, they would make bogus paths
+		n := 1 << z
+		if x < 0 || x >= n || row < 0 || row >= n {
+			log.Warn().
+				Int("z", z).
+				Int("x", x).
+				Int("row", row).
+				Msg("Skipping tile outside of its zoom level")
+			continue
+		}
+
+		y := n - 1 - row
+		outPath := filepath.Join(baseDir, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".webp")
+
+		if !force {
+			if info, err := os.Stat(outPath); err == nil && info.Size() > 0 {
+				continue
+			}
+		}
+
+		if format != "webp" {
+			data, err = transcodeToWebP(data)
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Int("z", z).
+					Int("x", x).
+					Int("y", y).
+					Msg("Skipping undecodable tile")
+				continue
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+			return count, err
+		}
+		if err := os.WriteFile(outPath, data, 0644); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, rows.Err()
+}
+
+// joinFloats formats the values as a comma separated MBTiles metadata list.
+func joinFloats(values ...float64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// transcodeToWebP decodes an image in any registered format and re-encodes it as WebP.
+func transcodeToWebP(data []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: 85}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,241 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/geo"
+	"github.com/woozymasta/dzmap/internal/pmtiles"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxLatE7 is the Web Mercator latitude limit the tile pyramid covers.
+const maxLatE7 = 850511287
+
+type pmtilesMetadata struct {
+	Name        string `json:"name"`
+	Format      string `json:"format"`
+	Type        string `json:"type"`
+	Attribution string `json:"attribution,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Fingerprint is the layerFingerprint of the settings the tiles were built with
+	Fingerprint string `json:"dzmap_fingerprint,omitempty"`
+}
+
+type tileFile struct {
+	Path string
+	ID   uint64
+}
+
+// PackPMTiles packs every downloaded tile layer of the map into a single
+// PMTiles v3 archive at maps/{name}/{layer}.pmtiles.
+// When prune is set the loose tile directory is removed after a successful pack,
+// the loader then skips the layer while the archive matches its settings.
+func PackPMTiles(m config.Map, defaultZoom int, prune bool) error {
+	for _, layer := range m.Layers {
+		typeName := layer.Name
+
+		baseDir := filepath.Join("maps", m.Name, typeName)
+		outPath := filepath.Join("maps", m.Name, typeName+".pmtiles")
+
+		if _, err := os.Stat(baseDir); os.IsNotExist(err) {
+			log.Debug().
+				Str("map", m.Name).
+				Str("layer", typeName).
+				Msg("Layer directory not found, skipping PMTiles packing")
+			continue
+		}
+
+		layerType := "baselayer"
+		if layer.Overlay {
+			layerType = "overlay"
+		}
+		attribution := layer.Attribution
+		if attribution == "" {
+			attribution = m.Attribution
+		}
+
+		meta := pmtilesMetadata{
+			Name:        m.Name + " " + typeName,
+			Format:      "webp",
+			Type:        layerType,
+			Attribution: attribution,
+			Description: fmt.Sprintf("DayZ %s %s tiles", m.Name, typeName),
+			Fingerprint: layerFingerprint(m, layer, layerZoom(m, layer, defaultZoom)),
+		}
+
+		count, err := packLayer(baseDir, outPath, layerHeader(m), meta)
+		if err != nil {
+			return fmt.Errorf("pack %s layer: %w", typeName, err)
+		}
+
+		log.Info().
+			Str("map", m.Name).
+			Str("layer", typeName).
+			Str("path", outPath).
+			Int("tiles", count).
+			Msg("Layer packed into PMTiles archive")
+
+		if prune {
+			if err := os.RemoveAll(baseDir); err != nil {
+				return err
+			}
+			log.Debug().Str("path", baseDir).Msg("Tile directory removed")
+		}
+	}
+
+	return nil
+}
+
+// archiveUpToDate reports whether the PMTiles archive at path was packed from
+// tiles built with the settings of fingerprint.
+func archiveUpToDate(path, fingerprint string) bool {
+	r, err := pmtiles.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = r.Close() }()
+
+	var meta pmtilesMetadata
+	if err := r.Metadata(&meta); err != nil {
+		return false
+	}
+
+	return meta.Fingerprint == fingerprint
+}
+
+// layerHeader returns the PMTiles header of the layers of the map. The bounds and
+// centre cover the square from game origin to the map size, as in TileJSON, or
+// the whole Web Mercator world when the size is unknown.
+func layerHeader(m config.Map) pmtiles.Header {
+	header := pmtiles.Header{
+		TileType:        pmtiles.TileTypeWebP,
+		TileCompression: pmtiles.CompressionNone,
+		MinLonE7:        -1800000000,
+		MinLatE7:        -maxLatE7,
+		MaxLonE7:        1800000000,
+		MaxLatE7:        maxLatE7,
+	}
+
+	if m.Size > 0 {
+		size := float64(m.Size)
+		minLon, minLat := geo.GameToMetricZ(0, 0, size)
+		maxLon, maxLat := geo.GameToMetricZ(size, size, size)
+		centerLon, centerLat := geo.GameToMetricZ(size/2, size/2, size)
+
+		header.MinLonE7, header.MinLatE7 = toE7(minLon), toE7(minLat)
+		header.MaxLonE7, header.MaxLatE7 = toE7(maxLon), toE7(maxLat)
+		header.CenterLonE7, header.CenterLatE7 = toE7(centerLon), toE7(centerLat)
+	}
+
+	return header
+}
+
+// toE7 converts degrees to the fixed point E7 form of PMTiles headers.
+func toE7(deg float64) int32 {
+	return int32(math.Round(deg * 1e7))
+}
+
+// packLayer writes all {z}/{x}/{y}.webp tiles of baseDir into a PMTiles archive
+// with the given header. The archive is written to a temporary file and renamed into place.
+func packLayer(baseDir, outPath string, header pmtiles.Header, meta pmtilesMetadata) (int, error) {
+	tiles, err := collectTiles(baseDir)
+	if err != nil {
+		return 0, err
+	}
+	if len(tiles) == 0 {
+		return 0, fmt.Errorf("no tiles found in %s", baseDir)
+	}
+
+	dir := filepath.Dir(outPath)
+	w, err := pmtiles.NewWriter(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = w.Close() }()
+
+	for _, t := range tiles {
+		data, err := os.ReadFile(t.Path)
+		if err != nil {
+			return 0, err
+		}
+		if err := w.WriteTile(t.ID, data); err != nil {
+			return 0, err
+		}
+	}
+
+	out, err := os.CreateTemp(dir, filepath.Base(outPath)+".*")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := out.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+
+	if err := w.Finalize(out, header, meta); err != nil {
+		_ = out.Close()
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return 0, err
+	}
+
+	return len(tiles), os.Rename(tmpName, outPath)
+}
+
+// collectTiles walks a tile pyramid directory and returns tiles sorted by PMTiles tile ID.
+func collectTiles(baseDir string) ([]tileFile, error) {
+	var tiles []tileFile
+
+	err := filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".webp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return err
+		}
+
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 {
+			return nil
+		}
+
+		z, errZ := strconv.ParseUint(parts[0], 10, 8)
+		x, errX := strconv.ParseUint(parts[1], 10, 32)
+		y, errY := strconv.ParseUint(strings.TrimSuffix(parts[2], ".webp"), 10, 32)
+		if errZ != nil || errX != nil || errY != nil {
+			log.Trace().Str("path", path).Msg("Skipping file with invalid tile coordinates")
+			return nil
+		}
+
+		if info, err := d.Info(); err != nil || info.Size() == 0 {
+			return nil
+		}
+
+		tiles = append(tiles, tileFile{
+			Path: path,
+			ID:   pmtiles.ZxyToID(uint8(z), uint32(x), uint32(y)),
+		})
+
+		return nil
+	})
+
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].ID < tiles[j].ID })
+
+	return tiles, err
+}
@@ -2,6 +2,8 @@ package processor
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"image"
 	"image/draw"
@@ -43,59 +45,98 @@ type result struct {
 // ProcessTiles handles the downloading or slicing of map tiles.
 // It supports both downloading from a URL template and slicing from a single large image.
 func ProcessTiles(client *http.Client, m config.Map, concurrency, defaultZoom int, force, fastCheck bool) {
-	types := map[string]string{
-		"topographic": m.Topographic,
-		"satellite":   m.Satellite,
-	}
+	for _, layer := range m.Layers {
+		if layer.Source == "" {
+			continue
+		}
 
-	zoomLimit := m.ZoomLimit
-	if zoomLimit <= 0 {
-		zoomLimit = defaultZoom
-	}
+		zoomLimit := layerZoom(m, layer, defaultZoom)
+		baseDir := filepath.Join("maps", m.Name, layer.Name)
+
+		// A layer pruned after packing only lives on in its PMTiles archive,
+		// don't download it again while the archive matches the source
+		archive := filepath.Join("maps", m.Name, layer.Name+".pmtiles")
+		if _, err := os.Stat(baseDir); !force && os.IsNotExist(err) && archiveUpToDate(archive, layerFingerprint(m, layer, zoomLimit)) {
+			log.Info().
+				Str("map", m.Name).
+				Str("layer", layer.Name).
+				Msg("Layer PMTiles archive is up to date, skipping")
 
-	for typeName, source := range types {
-		if source == "" {
 			continue
 		}
 
-		baseDir := filepath.Join("maps", m.Name, typeName)
-
 		// Fast Check
 		if fastCheck {
 			if _, err := os.Stat(baseDir); err == nil {
 				log.Info().
 					Str("map", m.Name).
-					Str("layer", typeName).
+					Str("layer", layer.Name).
 					Msg("Layer directory exists, skipping (fast-check)")
 
 				continue
 			}
+
+			if _, err := os.Stat(archive); err == nil {
+				log.Info().
+					Str("map", m.Name).
+					Str("layer", layer.Name).
+					Msg("Layer PMTiles archive exists, skipping (fast-check)")
+
+				continue
+			}
 		}
 
 		// Detect if source is a template or a single file
+		source := layer.Source
 		if strings.Contains(source, "{z}") || strings.Contains(source, "{x}") {
 			// --- Standard Download Mode ---
-			processDownloadMode(client, source, m.Name, typeName, zoomLimit, concurrency, force)
+			processDownloadMode(client, source, m.Name, layer.Name, zoomLimit, concurrency, force)
 		} else {
 			// --- Single Image Slicing Mode ---
 			log.Info().
 				Str("map", m.Name).
-				Str("layer", typeName).
+				Str("layer", layer.Name).
 				Str("source", source).
 				Msg("Starting single image processing (download & slice)")
 
-			tileSize := m.TileSize
-			if tileSize <= 0 {
-				tileSize = 256
-			}
-
-			if err := processSingleImage(client, source, baseDir, zoomLimit, tileSize, force); err != nil {
-				log.Error().Err(err).Str("map", m.Name).Msg("Failed to process single image")
+			if err := processSingleImage(client, source, baseDir, zoomLimit, layerTileSize(m, layer), force); err != nil {
+				log.Error().Err(err).Str("map", m.Name).Str("layer", layer.Name).Msg("Failed to process single image")
 			}
 		}
 	}
 }
 
+// layerZoom returns the zoom limit of the layer, falling back to the map and the default.
+func layerZoom(m config.Map, layer config.Layer, defaultZoom int) int {
+	if layer.ZoomLimit > 0 {
+		return layer.ZoomLimit
+	}
+	if m.ZoomLimit > 0 {
+		return m.ZoomLimit
+	}
+
+	return defaultZoom
+}
+
+// layerTileSize returns the slicing tile size of the layer, falling back to the map and 256.
+func layerTileSize(m config.Map, layer config.Layer) int {
+	if layer.TileSize > 0 {
+		return layer.TileSize
+	}
+	if m.TileSize > 0 {
+		return m.TileSize
+	}
+
+	return 256
+}
+
+// layerFingerprint identifies the settings the tiles of a layer are built with,
+// it is stored in PMTiles archives to tell whether they are up to date.
+func layerFingerprint(m config.Map, layer config.Layer, zoomLimit int) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\n%d\n%d", layer.Source, zoomLimit, layerTileSize(m, layer)))
+	return hex.EncodeToString(sum[:8])
+}
+
 // processDownloadMode handles the standard downloading of pre-tiled maps.
 func processDownloadMode(client *http.Client, urlTemplate, mapName, typeName string, zoomLimit, concurrency int, force bool) {
 	log.Info().
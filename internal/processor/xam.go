@@ -48,10 +48,7 @@ func fetchXam(client *http.Client, url string, mapSize int) (geo.GeoJSONFeatureC
 			name = loc.Names[0]
 		}
 
-		// Xam to Game conversion
-		xamY, xamX := loc.Pos[0], loc.Pos[1]
-		gameX := (xamX * float64(mapSize)) / 256.0
-		gameZ := ((256.0 + xamY) * float64(mapSize)) / 256.0
+		gameX, gameZ := geo.XamToGame(loc.Pos[0], loc.Pos[1], float64(mapSize))
 
 		wLon, wLat := geo.GameToMetricZ(gameX, gameZ, float64(mapSize))
 
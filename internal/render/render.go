@@ -0,0 +1,278 @@
+// Package render draws map overlays such as markers, paths and labels onto images
+// and encodes images in the formats served by the server.
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Image formats supported by Encode.
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+)
+
+// Quality is the lossy encoding quality used for JPEG and WebP.
+const Quality = 85
+
+// ErrUnsupportedFormat is returned for an unknown image format.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// namedColors are the colors accepted by name in ParseColor.
+var namedColors = map[string]color.RGBA{
+	"black":  {0x00, 0x00, 0x00, 0xff},
+	"white":  {0xff, 0xff, 0xff, 0xff},
+	"red":    {0xe0, 0x1b, 0x24, 0xff},
+	"green":  {0x2e, 0xc2, 0x7e, 0xff},
+	"blue":   {0x1c, 0x71, 0xd8, 0xff},
+	"yellow": {0xf6, 0xd3, 0x2d, 0xff},
+	"orange": {0xff, 0x78, 0x00, 0xff},
+	"purple": {0x91, 0x41, 0xac, 0xff},
+	"gray":   {0x77, 0x76, 0x7b, 0xff},
+}
+
+// ParseFormat normalizes an image format name or file extension.
+func ParseFormat(name string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "", "png":
+		return FormatPNG, nil
+	case "jpg", "jpeg":
+		return FormatJPEG, nil
+	case "webp":
+		return FormatWebP, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnsupportedFormat, name)
+	}
+}
+
+// ContentType returns the MIME type of a format returned by ParseFormat.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Encode writes img in the given format.
+// JPEG has no alpha channel, transparent areas are flattened onto white.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FormatPNG:
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		return enc.Encode(w, img)
+	case FormatJPEG:
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		return jpeg.Encode(w, flat, &jpeg.Options{Quality: Quality})
+	case FormatWebP:
+		return webp.Encode(w, img, &webp.Options{Lossless: false, Quality: Quality})
+	default:
+		return fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// ParseColor parses a color name, "#rrggbb", "rrggbb" or "0xrrggbb", optionally with alpha.
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, nil
+	}
+
+	hex := strings.TrimPrefix(strings.TrimPrefix(s, "#"), "0x")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+
+	// colors are drawn premultiplied
+	c := color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}
+	return color.RGBAModel.Convert(c).(color.RGBA), nil
+}
+
+// Disc fills a circle centred at (cx, cy).
+func Disc(dst draw.Image, cx, cy, radius float64, c color.Color) {
+	bounds := discBounds(cx, cy, radius).Intersect(dst.Bounds())
+	if bounds.Empty() {
+		return
+	}
+
+	mask := image.NewAlpha(bounds)
+	fillDisc(mask, cx, cy, radius)
+	draw.DrawMask(dst, bounds, image.NewUniform(c), image.Point{}, mask, bounds.Min, draw.Over)
+}
+
+// Line draws a polyline of the given width through the points.
+// mask is scratch space covering dst that may be shared by several calls,
+// it is left clear on return. A nil mask is allocated for the call.
+func Line(dst draw.Image, mask *image.Alpha, points []image.Point, width float64, c color.Color) {
+	if len(points) == 0 {
+		return
+	}
+	if mask == nil {
+		mask = image.NewAlpha(dst.Bounds())
+	}
+
+	radius := math.Max(width/2, 0.5)
+	// segments are clipped to the area where the pen can still touch the mask
+	clip := mask.Bounds()
+	minX, minY := float64(clip.Min.X)-radius, float64(clip.Min.Y)-radius
+	maxX, maxY := float64(clip.Max.X)+radius, float64(clip.Max.Y)+radius
+
+	var dirty image.Rectangle
+	for i := range points {
+		a, b := points[i], points[i]
+		if i > 0 {
+			a = points[i-1]
+		}
+
+		ax, ay, bx, by, ok := clipSegment(float64(a.X), float64(a.Y), float64(b.X), float64(b.Y), minX, minY, maxX, maxY)
+		if !ok {
+			continue
+		}
+
+		// stamp the pen along the segment
+		dx, dy := bx-ax, by-ay
+		steps := int(math.Ceil(math.Hypot(dx, dy) / math.Max(radius/2, 0.5)))
+		for s := 0; s <= steps; s++ {
+			t := 0.0
+			if steps > 0 {
+				t = float64(s) / float64(steps)
+			}
+			dirty = dirty.Union(fillDisc(mask, ax+dx*t, ay+dy*t, radius))
+		}
+	}
+
+	if r := dirty.Intersect(dst.Bounds()); !r.Empty() {
+		draw.DrawMask(dst, r, image.NewUniform(c), image.Point{}, mask, r.Min, draw.Over)
+	}
+
+	// leave the mask clear for the next line
+	for y := dirty.Min.Y; y < dirty.Max.Y; y++ {
+		off := mask.PixOffset(dirty.Min.X, y)
+		clear(mask.Pix[off : off+dirty.Dx()])
+	}
+}
+
+// discBounds returns the pixels a circle centred at (cx, cy) may touch.
+func discBounds(cx, cy, radius float64) image.Rectangle {
+	return image.Rect(
+		int(math.Floor(cx-radius)), int(math.Floor(cy-radius)),
+		int(math.Ceil(cx+radius))+1, int(math.Ceil(cy+radius))+1,
+	)
+}
+
+// fillDisc sets the mask pixels whose centre lies inside the circle and
+// returns the rectangle it changed.
+func fillDisc(mask *image.Alpha, cx, cy, radius float64) image.Rectangle {
+	bounds := discBounds(cx, cy, radius).Intersect(mask.Bounds())
+
+	r2 := radius * radius
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		dy := float64(y) + 0.5 - cy
+		if dy*dy > r2 {
+			continue
+		}
+
+		// span of pixel centres within the circle on this row
+		h := math.Sqrt(r2 - dy*dy)
+		x0 := max(int(math.Ceil(cx-h-0.5)), bounds.Min.X)
+		x1 := min(int(math.Floor(cx+h-0.5))+1, bounds.Max.X)
+		if x0 >= x1 {
+			continue
+		}
+
+		off := mask.PixOffset(x0, y)
+		for i := range mask.Pix[off : off+x1-x0] {
+			mask.Pix[off+i] = 0xff
+		}
+	}
+
+	return bounds
+}
+
+// clipSegment clips the segment a-b to the rectangle (Liang-Barsky) and
+// reports false when no part of it lies inside.
+func clipSegment(ax, ay, bx, by, minX, minY, maxX, maxY float64) (float64, float64, float64, float64, bool) {
+	dx, dy := bx-ax, by-ay
+	t0, t1 := 0.0, 1.0
+	for _, e := range [4][2]float64{{-dx, ax - minX}, {dx, maxX - ax}, {-dy, ay - minY}, {dy, maxY - ay}} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			// parallel to the edge
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return 0, 0, 0, 0, false
+		}
+	}
+
+	return ax + dx*t0, ay + dy*t0, ax + dx*t1, ay + dy*t1, true
+}
+
+// Marker draws a round marker with a white outline at p.
+func Marker(dst draw.Image, p image.Point, c color.Color) {
+	cx, cy := float64(p.X)+0.5, float64(p.Y)+0.5
+	Disc(dst, cx, cy+1, 8, color.RGBA{0, 0, 0, 0x60}) // shadow
+	Disc(dst, cx, cy, 8, color.White)
+	Disc(dst, cx, cy, 6, c)
+}
+
+// Label draws text centred on p, outlined so it stays readable on any background.
+func Label(dst draw.Image, p image.Point, text string, c color.Color) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Round()
+	x, y := p.X-width/2, p.Y+face.Ascent/2
+
+	// dark text gets a light outline and vice versa
+	var outline color.Color = color.Black
+	if color.GrayModel.Convert(c).(color.Gray).Y < 0x80 {
+		outline = color.White
+	}
+
+	for _, off := range []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {-1, -1}, {1, 1}, {-1, 1}, {1, -1}} {
+		drawText(dst, x+off.X, y+off.Y, text, outline)
+	}
+	drawText(dst, x, y, text, c)
+}
+
+// drawText draws text with its baseline starting at (x, y).
+func drawText(dst draw.Image, x, y int, text string, c color.Color) {
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
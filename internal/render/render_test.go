@@ -0,0 +1,86 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestLineOffCanvas(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+
+	tests := []struct {
+		name   string
+		points []image.Point
+		inked  []image.Point
+		blank  []image.Point
+	}{
+		{
+			name:   "crossing the canvas",
+			points: []image.Point{{-1e12, 5}, {1e12, 5}},
+			inked:  []image.Point{{0, 5}, {31, 5}, {63, 5}, {20, 4}},
+			blank:  []image.Point{{0, 0}, {20, 7}, {63, 15}},
+		},
+		{
+			name:   "outside the canvas",
+			points: []image.Point{{-1e12, -100}, {1e12, -100}, {1e12, 1e12}},
+			blank:  []image.Point{{0, 0}, {63, 0}, {63, 15}},
+		},
+		{
+			name:   "single point",
+			points: []image.Point{{10, 10}},
+			inked:  []image.Point{{10, 10}},
+			blank:  []image.Point{{14, 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := image.NewRGBA(image.Rect(0, 0, 64, 16))
+			mask := image.NewAlpha(dst.Bounds())
+			Line(dst, mask, tt.points, 3, red)
+
+			for _, p := range tt.inked {
+				if got := dst.RGBAAt(p.X, p.Y); got != red {
+					t.Errorf("pixel %v = %v, want %v", p, got, red)
+				}
+			}
+			for _, p := range tt.blank {
+				if got := dst.RGBAAt(p.X, p.Y); got.A != 0 {
+					t.Errorf("pixel %v = %v, want transparent", p, got)
+				}
+			}
+			for i, a := range mask.Pix {
+				if a != 0 {
+					t.Fatalf("mask byte %d = %d after Line, want a clear mask", i, a)
+				}
+			}
+		})
+	}
+}
+
+func TestDisc(t *testing.T) {
+	dst := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	Disc(dst, 8, 8, 3, color.White)
+
+	for _, tt := range []struct {
+		x, y int
+		in   bool
+	}{
+		{8, 8, true},
+		{5, 7, true},
+		{10, 7, true},
+		{7, 5, true},
+		{4, 8, false},
+		{5, 5, false},
+		{11, 8, false},
+	} {
+		if got := dst.RGBAAt(tt.x, tt.y).A != 0; got != tt.in {
+			t.Errorf("pixel %d,%d inside = %v, want %v", tt.x, tt.y, got, tt.in)
+		}
+	}
+
+	// discs off the image draw nothing
+	Disc(dst, -100, -100, 8, color.White)
+	Disc(dst, 1e12, 8, 8, color.White)
+}
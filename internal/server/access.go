@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/woozymasta/dzmap/internal/config"
+)
+
+const (
+	// signDefaultTTL is the lifetime of signed URLs when the request sets none
+	signDefaultTTL = time.Hour
+	// signMaxTTL is the longest lifetime of signed URLs
+	signMaxTTL = 30 * 24 * time.Hour
+)
+
+// SignedURL is the response of the URL signing endpoint.
+type SignedURL struct {
+	Map     string `json:"map"`
+	Query   string `json:"query"`
+	Tiles   string `json:"tiles"`
+	Expires int64  `json:"expires"`
+}
+
+// apiKeyHash hashes API keys, so looking them up does not compare secrets directly.
+func apiKeyHash(key string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(key))
+}
+
+// indexAPIKeys maps the hashes of the configured API keys to their settings.
+func indexAPIKeys(keys []config.APIKey) map[[sha256.Size]byte]config.APIKey {
+	index := make(map[[sha256.Size]byte]config.APIKey, len(keys))
+	for _, k := range keys {
+		index[apiKeyHash(k.Key)] = k
+	}
+
+	return index
+}
+
+// resolveMap returns the canonical name of a map by name or alias, when the request
+// may access it. Private maps the request has no access to are reported as unknown,
+// responses for the accessible ones are marked private, see markPrivate.
+func (s *ServerContext) resolveMap(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	mapName, ok := s.MapNameResolver[name]
+	if !ok {
+		return "", false
+	}
+
+	world := s.findMap(mapName)
+	if world == nil || !world.IsPrivate() {
+		return mapName, true
+	}
+	if !s.canAccess(r, mapName) {
+		return "", false
+	}
+
+	markPrivate(w, world)
+	return mapName, true
+}
+
+// canAccess reports whether the request carries an API key or a signed URL
+// granting access to the private map.
+func (s *ServerContext) canAccess(r *http.Request, mapName string) bool {
+	if key, ok := s.apiKey(r); ok && (len(key.Maps) == 0 || slices.Contains(key.Maps, mapName)) {
+		return true
+	}
+
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires || s.urlSecret == "" {
+		return false
+	}
+
+	sig, err := hex.DecodeString(q.Get("signature"))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(sig, s.sign(mapName, expires))
+}
+
+// IsAPIKey reports whether key is one of the configured API keys.
+func (h *Holder) IsAPIKey(key string) bool {
+	_, ok := h.Current().apiKeys[apiKeyHash(key)]
+	return ok
+}
+
+// apiKey returns the configured API key sent with the request.
+func (s *ServerContext) apiKey(r *http.Request) (config.APIKey, bool) {
+	raw := requestAPIKey(r)
+	if raw == "" {
+		return config.APIKey{}, false
+	}
+
+	key, ok := s.apiKeys[apiKeyHash(raw)]
+	return key, ok
+}
+
+// sign computes the signature of URLs for the map valid until expires.
+func (s *ServerContext) sign(mapName string, expires int64) []byte {
+	mac := hmac.New(sha256.New, []byte(s.urlSecret))
+	mac.Write([]byte(mapName + "\n" + strconv.FormatInt(expires, 10)))
+
+	return mac.Sum(nil)
+}
+
+// visibleMaps returns the maps the request may access.
+func (s *ServerContext) visibleMaps(r *http.Request) []config.Map {
+	maps := make([]config.Map, 0, len(s.Config.Maps))
+	for _, m := range s.Config.Maps {
+		if !m.IsPrivate() || s.canAccess(r, m.Name) {
+			maps = append(maps, m)
+		}
+	}
+
+	return maps
+}
+
+// accessQuery returns the query string carrying the credentials of the request,
+// with a leading "?", to pass them on in URLs of private maps documents.
+func accessQuery(r *http.Request, world *config.Map) string {
+	if !world.IsPrivate() {
+		return ""
+	}
+
+	q := r.URL.Query()
+	creds := url.Values{}
+	for _, name := range []string{"api_key", "expires", "signature"} {
+		if v := q.Get(name); v != "" {
+			creds.Set(name, v)
+		}
+	}
+	if len(creds) == 0 {
+		return ""
+	}
+
+	return "?" + creds.Encode()
+}
+
+// markPrivate keeps the response of a private map out of shared caches,
+// see setCacheControl.
+func markPrivate(w http.ResponseWriter, world *config.Map) {
+	if world != nil && world.IsPrivate() {
+		w.Header().Set("Cache-Control", "private")
+	}
+}
+
+// setCacheControl sets the Cache-Control header, turning public directives private
+// for responses marked by markPrivate.
+func setCacheControl(w http.ResponseWriter, value string) {
+	if strings.HasPrefix(w.Header().Get("Cache-Control"), "private") {
+		value = strings.Replace(value, "public", "private", 1)
+	}
+
+	w.Header().Set("Cache-Control", value)
+}
+
+// handleSign issues a signed URL query for the map to requests with a valid API key.
+// The ttl query parameter sets its lifetime, one hour by default.
+func (s *ServerContext) handleSign(w http.ResponseWriter, r *http.Request, mapName string) {
+	if s.urlSecret == "" {
+		writeError(w, http.StatusNotFound, "signed URLs are not configured")
+		return
+	}
+
+	key, ok := s.apiKey(r)
+	if !ok || (len(key.Maps) > 0 && !slices.Contains(key.Maps, mapName)) {
+		writeError(w, http.StatusForbidden, "a valid API key is required")
+		return
+	}
+
+	ttl := signDefaultTTL
+	if raw := r.URL.Query().Get("ttl"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > signMaxTTL {
+			writeError(w, http.StatusBadRequest, "ttl must be a duration up to "+signMaxTTL.String())
+			return
+		}
+		ttl = d
+	}
+
+	expires := time.Now().Add(ttl).Unix()
+	query := url.Values{
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {hex.EncodeToString(s.sign(mapName, expires))},
+	}.Encode()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(SignedURL{
+		Map:     mapName,
+		Query:   query,
+		Tiles:   s.baseURL(r) + "/maps/" + mapName + "/{layer}/{z}/{x}/{y}.webp?" + query,
+		Expires: expires,
+	})
+}
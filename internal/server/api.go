@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/geo"
+)
+
+// locationMinZoom is the zoom level from which a location type is shown.
+// It mirrors the view-level rules of the web viewer, unknown types show from level 4.
+var locationMinZoom = map[string]int{
+	"capital": 1,
+	"city":    2,
+	"village": 3,
+}
+
+const defaultLocationMinZoom = 4
+
+// apiError is the JSON body of API error responses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// HandleMapAPI serves per-map API resources under /api/maps/{name}/.
+func (s *ServerContext) HandleMapAPI(w http.ResponseWriter, r *http.Request) {
+	// Path: /api/maps/{mapName}/{resource}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 {
+		http.NotFound(w, r)
+		return
+	}
+
+	realMapName, ok := s.resolveMap(w, r, parts[2])
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown map")
+		return
+	}
+
+	switch parts[3] {
+	case "locations":
+		s.handleLocationsQuery(w, r, realMapName)
+	case "reverse":
+		s.handleReverse(w, r, realMapName)
+	case "sign":
+		s.handleSign(w, r, realMapName)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleLocationsQuery returns the map locations filtered by bounding box, type and zoom.
+//
+// Query parameters:
+//   - bbox: minX,minY,maxX,maxY in the coordinate system given by crs
+//   - crs: "wgs84" (lon/lat, default) or "game" (x/z metres)
+//   - type: comma separated list of location types
+//   - zoom: only types visible at this zoom level
+//   - limit: maximum number of features, most important types first
+func (s *ServerContext) handleLocationsQuery(w http.ResponseWriter, r *http.Request, mapName string) {
+	locs := s.locations[mapName]
+	if locs == nil {
+		writeError(w, http.StatusNotFound, "map has no locations")
+		return
+	}
+
+	q := r.URL.Query()
+	world := s.findMap(mapName)
+
+	var bbox *[4]float64
+	if raw := q.Get("bbox"); raw != "" {
+		b, err := parseBBox(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		switch crs := strings.ToLower(q.Get("crs")); crs {
+		case "", "wgs84", "epsg:4326":
+		case "game":
+			if world == nil || world.Size <= 0 {
+				writeError(w, http.StatusBadRequest, "map size is unknown, game coordinates are not supported")
+				return
+			}
+			size := float64(world.Size)
+			b[0], b[1] = geo.GameToMetricZ(b[0], b[1], size)
+			b[2], b[3] = geo.GameToMetricZ(b[2], b[3], size)
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported crs %q", crs))
+			return
+		}
+		bbox = &b
+	}
+
+	var types map[string]bool
+	if raw := q.Get("type"); raw != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(raw, ",") {
+			if t = strings.TrimSpace(strings.ToLower(t)); t != "" {
+				types[t] = true
+			}
+		}
+	}
+
+	zoom := -1
+	if raw := q.Get("zoom"); raw != "" {
+		z, err := strconv.Atoi(raw)
+		if err != nil || z < 0 {
+			writeError(w, http.StatusBadRequest, "invalid zoom")
+			return
+		}
+		zoom = z
+	}
+
+	limit := 0
+	if raw := q.Get("limit"); raw != "" {
+		l, err := strconv.Atoi(raw)
+		if err != nil || l < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = l
+	}
+
+	features := make([]geo.GeoJSONFeature, 0)
+	for _, f := range locs.Features {
+		if len(f.Geometry.Coordinates) < 2 {
+			continue
+		}
+
+		typ, _ := f.Properties["type"].(string)
+		if types != nil && !types[typ] {
+			continue
+		}
+		if zoom >= 0 && zoom < minZoomForType(typ) {
+			continue
+		}
+
+		if bbox != nil {
+			lon, lat := f.Geometry.Coordinates[0], f.Geometry.Coordinates[1]
+			if lon < bbox[0] || lat < bbox[1] || lon > bbox[2] || lat > bbox[3] {
+				continue
+			}
+		}
+
+		features = append(features, f)
+	}
+
+	if limit > 0 && len(features) > limit {
+		sort.SliceStable(features, func(i, j int) bool {
+			ti, _ := features[i].Properties["type"].(string)
+			tj, _ := features[j].Properties["type"].(string)
+			return minZoomForType(ti) < minZoomForType(tj)
+		})
+		features = features[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	setCacheControl(w, "public, no-cache")
+	_ = json.NewEncoder(w).Encode(geo.GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: features,
+	})
+}
+
+// minZoomForType returns the zoom level from which a location type is shown.
+func minZoomForType(typ string) int {
+	if z, ok := locationMinZoom[typ]; ok {
+		return z
+	}
+
+	return defaultLocationMinZoom
+}
+
+// parseBBox parses "minX,minY,maxX,maxY".
+func parseBBox(raw string) ([4]float64, error) {
+	var b [4]float64
+
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return b, fmt.Errorf("bbox must have 4 comma separated values")
+	}
+
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || !finite(v) {
+			return b, fmt.Errorf("invalid bbox value %q", p)
+		}
+		b[i] = v
+	}
+
+	if b[0] > b[2] || b[1] > b[3] {
+		return b, fmt.Errorf("bbox minimum is greater than maximum")
+	}
+
+	return b, nil
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Error: msg})
+}
+
+// finite reports whether none of the values is NaN or infinite.
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+
+	return true
+}
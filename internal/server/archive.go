@@ -0,0 +1,209 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// archiveMaxTileSize limits the size of a tile read from an archive, larger
+// entries are left out of the index.
+const archiveMaxTileSize = 4 << 20
+
+// archiveExtensions are the tile archive formats in order of precedence.
+var archiveExtensions = []string{".zip", ".tar"}
+
+// tileIndex is the z, x, y position of a tile.
+type tileIndex [3]int
+
+// archiveEntry locates a tile in an archive. Stored entries are read at offset,
+// compressed zip entries through file.
+type archiveEntry struct {
+	modTime time.Time
+	file    *zip.File
+	offset  int64
+	size    int64
+}
+
+// archiveSource serves tiles from a zip or uncompressed tar archive of a
+// {z}/{x}/{y}.webp tree, using an index of entry offsets built on open.
+// Tiles missing in the archive are read from fallback when set.
+type archiveSource struct {
+	file     *os.File
+	entries  map[tileIndex]archiveEntry
+	fallback tileSource
+}
+
+// openArchive indexes the tiles of a .zip or .tar archive.
+func openArchive(path string, fallback tileSource) (*archiveSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	var entries map[tileIndex]archiveEntry
+	if strings.HasSuffix(path, ".zip") {
+		entries, err = indexZip(f, info.Size(), info.ModTime())
+	} else {
+		entries, err = indexTar(f, info.ModTime())
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("index %s: %w", path, err)
+	}
+
+	return &archiveSource{file: f, entries: entries, fallback: fallback}, nil
+}
+
+// indexZip indexes the tile entries of a zip archive.
+func indexZip(f *os.File, size int64, modTime time.Time) (map[tileIndex]archiveEntry, error) {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[tileIndex]archiveEntry, len(zr.File))
+	for _, zf := range zr.File {
+		idx, ok := parseTileEntry(zf.Name)
+		if !ok || zf.FileInfo().IsDir() {
+			continue
+		}
+
+		if zf.UncompressedSize64 > archiveMaxTileSize {
+			skipLargeEntry(zf.Name, int64(zf.UncompressedSize64))
+			continue
+		}
+
+		e := archiveEntry{modTime: zf.Modified, size: int64(zf.UncompressedSize64)}
+		if zf.Method == zip.Store {
+			if e.offset, err = zf.DataOffset(); err != nil {
+				return nil, err
+			}
+		} else {
+			e.file = zf
+		}
+		if e.modTime.IsZero() {
+			e.modTime = modTime
+		}
+
+		entries[idx] = e
+	}
+
+	return entries, nil
+}
+
+// indexTar indexes the tile entries of an uncompressed tar archive.
+// tar.Reader reads headers without buffering, so after Next the file
+// position is the start of the entry data.
+func indexTar(f *os.File, modTime time.Time) (map[tileIndex]archiveEntry, error) {
+	tr := tar.NewReader(f)
+	entries := make(map[tileIndex]archiveEntry)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		idx, ok := parseTileEntry(hdr.Name)
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > archiveMaxTileSize {
+			skipLargeEntry(hdr.Name, hdr.Size)
+			continue
+		}
+
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		e := archiveEntry{modTime: hdr.ModTime, offset: offset, size: hdr.Size}
+		if e.modTime.IsZero() {
+			e.modTime = modTime
+		}
+
+		entries[idx] = e
+	}
+}
+
+// skipLargeEntry logs an archive entry left out of the index for its size.
+func skipLargeEntry(name string, size int64) {
+	log.Warn().
+		Str("entry", name).
+		Int64("size", size).
+		Int("limit", archiveMaxTileSize).
+		Msg("Skipping archive entry larger than a tile can be")
+}
+
+// parseTileEntry parses the {z}/{x}/{y}.webp tail of an archive entry name,
+// so archives may wrap the tree in a directory such as the layer name.
+func parseTileEntry(name string) (tileIndex, bool) {
+	parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	if len(parts) < 3 {
+		return tileIndex{}, false
+	}
+
+	parts = parts[len(parts)-3:]
+	z, x, y, ok := parseTileCoords(parts[0], parts[1], parts[2], ".webp")
+	if !ok {
+		return tileIndex{}, false
+	}
+
+	return tileIndex{z, x, y}, true
+}
+
+// Tile implements tileSource.
+func (a *archiveSource) Tile(z, x, y int) (tile, bool, error) {
+	e, ok := a.entries[tileIndex{z, x, y}]
+	if !ok {
+		if a.fallback != nil {
+			return a.fallback.Tile(z, x, y)
+		}
+		return tile{}, false, nil
+	}
+
+	data := make([]byte, e.size)
+	if e.file != nil {
+		rc, err := e.file.Open()
+		if err != nil {
+			return tile{}, false, err
+		}
+		defer func() { _ = rc.Close() }()
+
+		// never read more than a tile can be, whatever the entry declares
+		if _, err := io.ReadFull(io.LimitReader(rc, archiveMaxTileSize), data); err != nil {
+			return tile{}, false, fmt.Errorf("read %s: %w", e.file.Name, err)
+		}
+	} else if _, err := a.file.ReadAt(data, e.offset); err != nil {
+		return tile{}, false, fmt.Errorf("read tile %d/%d/%d: %w", z, x, y, err)
+	}
+
+	return tile{Data: data, ModTime: e.modTime}, true, nil
+}
+
+// Close implements tileSource.
+func (a *archiveSource) Close() error {
+	if a.fallback != nil {
+		_ = a.fallback.Close()
+	}
+
+	return a.file.Close()
+}
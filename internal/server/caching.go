@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/manifest"
+)
+
+// immutableCacheControl is sent for stored tiles and files of versioned URLs,
+// their content never changes.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// etagHashLength is the number of hex digits of content hash ETags.
+const etagHashLength = 32
+
+// Revalidation holds the Cache-Control policy of the stable, unversioned tile URLs.
+// Their content changes when the tiles are rebuilt, so by default clients
+// revalidate them on every use; versioned URLs are always immutable.
+type Revalidation struct {
+	MaxAge               time.Duration `long:"max-age"                env:"MAX_AGE"                description:"How long clients may use tiles of stable URLs without revalidating, 0 revalidates every time" default:"0s"`
+	StaleWhileRevalidate time.Duration `long:"stale-while-revalidate" env:"STALE_WHILE_REVALIDATE" description:"How long clients may use outdated tiles of stable URLs while revalidating them in the background"`
+}
+
+// CacheControl returns the Cache-Control header of stable tile URLs.
+func (v *Revalidation) CacheControl() string {
+	if v.MaxAge <= 0 && v.StaleWhileRevalidate <= 0 {
+		return "public, no-cache"
+	}
+
+	value := "public, max-age=" + strconv.Itoa(int(v.MaxAge.Seconds()))
+	if v.StaleWhileRevalidate > 0 {
+		value += ", stale-while-revalidate=" + strconv.Itoa(int(v.StaleWhileRevalidate.Seconds()))
+	}
+
+	return value
+}
+
+// contentETag builds a strong ETag from a hash of the content, so it survives
+// rebuilds producing the same bytes.
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:])[:etagHashLength] + `"`
+}
+
+// etagCacheSize is the budget of the ETags remembered for tiles read without the tile cache.
+const etagCacheSize = 8 << 20
+
+// etagEntrySize approximates the memory used by an entry of the ETag cache.
+const etagEntrySize = 160
+
+// fileHashLimit is the size up to which files served from disk get a content hash ETag,
+// hashing larger ones would stall the first request, see serveFile.
+const fileHashLimit = 16 << 20
+
+// weakETag builds a weak ETag from the size and modification time of a file.
+func weakETag(info fs.FileInfo) string {
+	return `W/"` + strconv.FormatInt(info.Size(), 16) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 16) + `"`
+}
+
+// fileETag is a content hash ETag of a file or tile, valid while size and
+// modification time are unchanged.
+type fileETag struct {
+	modTime time.Time
+	etag    string
+	size    int64
+}
+
+// fileETag returns the content hash ETag of the file, hashing it on first use
+// and again only after it changed.
+func (s *ServerContext) fileETag(path string, info fs.FileInfo) (string, error) {
+	if v, ok := s.fileETags.Load(path); ok {
+		e := v.(fileETag)
+		if e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+			return e.etag, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	etag := `"` + hex.EncodeToString(h.Sum(nil))[:etagHashLength] + `"`
+	s.fileETags.Store(path, fileETag{modTime: info.ModTime(), etag: etag, size: info.Size()})
+
+	return etag, nil
+}
+
+// tileETag returns the content hash ETag of a tile. Cached tiles carry it already,
+// others are hashed once and remembered while their size and modification time
+// are unchanged, so serving a tile does not hash it again.
+func (s *ServerContext) tileETag(key tileKey, t tile) string {
+	if t.ETag != "" {
+		return t.ETag
+	}
+
+	if e, ok := s.etags.Get(key); ok && e.size == int64(len(t.Data)) && e.modTime.Equal(t.ModTime) {
+		return e.etag
+	}
+
+	etag := contentETag(t.Data)
+	s.etags.Add(key, fileETag{modTime: t.ModTime, etag: etag, size: int64(len(t.Data))}, etagEntrySize)
+
+	return etag
+}
+
+// splitVersion splits the "{name}@{version}" map path segment of versioned URLs.
+func splitVersion(segment string) (name, version string) {
+	name, version, _ = strings.Cut(segment, "@")
+	return name, version
+}
+
+// versionedPath returns the map path segment of the versioned URLs of the map,
+// or its name when it has no version.
+func versionedPath(world *config.Map) string {
+	if world.Version == "" {
+		return world.Name
+	}
+
+	return world.Name + "@" + world.Version
+}
+
+// mapLayers returns the names of the layers of the map.
+func mapLayers(world *config.Map) []string {
+	layers := make([]string, 0, len(world.Layers))
+	for _, l := range world.Layers {
+		layers = append(layers, l.Name)
+	}
+
+	return layers
+}
+
+// versionMaps sets the content versions of the maps of a new context and lists
+// those without one. When the manifests are unchanged since prev was built, the
+// versions of prev are reused instead of checking the map files again.
+func (h *Holder) versionMaps(srvCtx, prev *ServerContext) {
+	srvCtx.unversioned = nil
+	for i := range srvCtx.Config.Maps {
+		world := &srvCtx.Config.Maps[i]
+
+		world.Version = ""
+		if prev != nil && prev.manifestState == srvCtx.manifestState {
+			if old := prev.findMap(world.Name); old != nil && old.Version != "" && slices.Equal(mapLayers(old), mapLayers(world)) {
+				world.Version = old.Version
+			}
+		}
+		if world.Version == "" {
+			world.Version = h.mapVersion(world)
+		}
+
+		if world.Version == "" {
+			srvCtx.unversioned = append(srvCtx.unversioned, world.Name)
+		}
+	}
+}
+
+// mapVersion returns the content version of the map from its manifest when the
+// manifest still matches the map files, comparing only their sizes and modification
+// times. Otherwise it returns an empty version, versioned URLs stay disabled until
+// the manifest is rebuilt in the background, see Holder.buildManifests.
+func (h *Holder) mapVersion(world *config.Map) string {
+	dir := filepath.Join("maps", world.Name)
+	layers := mapLayers(world)
+
+	// the manifest of the map directory, then the one hashed by the server
+	var manifests []*manifest.Manifest
+	if mf, err := manifest.Read(dir); err == nil {
+		manifests = append(manifests, mf)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Warn().Err(err).Str("map", world.Name).Msg("Failed to read manifest")
+	}
+	if v, ok := h.built.Load(dir); ok {
+		manifests = append(manifests, v.(*manifest.Manifest))
+	}
+
+	for _, mf := range manifests {
+		current, err := mf.Current(dir, layers)
+		if err != nil {
+			log.Warn().Err(err).Str("map", world.Name).Msg("Failed to check manifest")
+			return ""
+		}
+		if current {
+			return mf.Version
+		}
+	}
+
+	return ""
+}
+
+// manifestState describes the manifest files of the maps by their sizes and
+// modification times, a change tells that a map was rebuilt.
+func manifestState(maps []config.Map) string {
+	var b strings.Builder
+	for _, world := range maps {
+		b.WriteString(world.Name)
+		if info, err := os.Stat(filepath.Join("maps", world.Name, manifest.FileName)); err == nil {
+			b.WriteString(":" + strconv.FormatInt(info.Size(), 10) + ":" + strconv.FormatInt(info.ModTime().UnixNano(), 10))
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// buildManifest hashes the files of the map and stores the manifest in the map
+// directory, or in memory when the directory is not writable.
+func (h *Holder) buildManifest(world *config.Map) error {
+	dir := filepath.Join("maps", world.Name)
+	start := time.Now()
+
+	mf, err := manifest.Build(dir, mapLayers(world))
+	if err != nil {
+		return err
+	}
+	h.built.Store(dir, mf)
+
+	if err := manifest.Write(dir, mf); err != nil {
+		log.Debug().Err(err).Str("map", world.Name).Msg("Failed to write manifest, keeping it in memory")
+	}
+
+	log.Info().
+		Str("map", world.Name).
+		Str("version", mf.Version).
+		Dur("duration", time.Since(start)).
+		Msg("Map files hashed")
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/coord"
+)
+
+const (
+	// convertMaxBody limits the size of batch conversion requests
+	convertMaxBody = 1 << 20
+	// convertMaxBatch limits the number of points of a batch conversion request
+	convertMaxBatch = 10000
+)
+
+// HandleConvert converts coordinates between game, WGS84, tile and grid reference systems.
+//
+// GET converts a single point given by one of:
+//   - x, z: game coordinates in metres
+//   - lon, lat: WGS84 coordinates
+//   - grid: in-game grid reference such as "045 123"
+//   - tile: "z/x/y" with optional px, py pixel
+//
+// POST converts a batch: a JSON object or array of objects with the same fields,
+// or CSV records (Content-Type text/csv) in the system given by the from parameter.
+//
+// Common parameters are map (name or alias, required), zoom for the tile
+// (defaults to the map zoom limit), layer for the tile URL and format=csv.
+func (s *ServerContext) HandleConvert(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	realMapName, ok := s.resolveMap(w, r, q.Get("map"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown map")
+		return
+	}
+	world := s.findMap(realMapName)
+	if world == nil || world.Size <= 0 {
+		writeError(w, http.StatusBadRequest, "map size is unknown, conversion is not supported")
+		return
+	}
+
+	zoom := world.ZoomLimit
+	if raw := q.Get("zoom"); raw != "" {
+		z, err := strconv.Atoi(raw)
+		if err != nil || z < 0 || z > coord.MaxZoom {
+			writeError(w, http.StatusBadRequest, "invalid zoom")
+			return
+		}
+		zoom = z
+	}
+
+	layer := q.Get("layer")
+	if layer == "" {
+		layer = s.defaultStyle(realMapName)
+	}
+	if !s.hasLayer(realMapName, layer) {
+		writeError(w, http.StatusNotFound, "unknown layer")
+		return
+	}
+
+	from, err := coord.ParseSystem(q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conv := coord.Converter{
+		Size:    float64(world.Size),
+		Zoom:    zoom,
+		TileURL: s.baseURL(r) + "/maps/" + realMapName + "/" + layer + "/{z}/{x}/{y}.webp",
+	}
+
+	var batch []coord.Input
+	single := false
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		in, err := convertQueryInput(q)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		batch, single = []coord.Input{in}, true
+
+	case http.MethodPost:
+		body := http.MaxBytesReader(w, r.Body, convertMaxBody)
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "text/csv" {
+			batch, err = coord.ReadCSV(body, from)
+		} else {
+			batch, err = coord.ReadJSON(body)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(batch) > convertMaxBatch {
+			writeError(w, http.StatusRequestEntityTooLarge, "too many points")
+			return
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	positions := make([]coord.Position, 0, len(batch))
+	for i, in := range batch {
+		p, err := conv.Convert(in)
+		if err != nil {
+			msg := err.Error()
+			if !single {
+				msg = "point " + strconv.Itoa(i) + ": " + msg
+			}
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+		positions = append(positions, p)
+	}
+
+	if q.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		_ = coord.WriteCSV(w, positions)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if single {
+		_ = json.NewEncoder(w).Encode(positions[0])
+		return
+	}
+	_ = json.NewEncoder(w).Encode(positions)
+}
+
+// convertQueryInput reads a single point from query parameters.
+func convertQueryInput(q url.Values) (coord.Input, error) {
+	switch {
+	case q.Get("x") != "" || q.Get("z") != "":
+		return coord.ParseValues(coord.Game, []string{q.Get("x"), q.Get("z")})
+	case q.Get("lon") != "" || q.Get("lat") != "":
+		return coord.ParseValues(coord.WGS84, []string{q.Get("lon"), q.Get("lat")})
+	case q.Get("grid") != "":
+		return coord.ParseValues(coord.Grid, []string{q.Get("grid")})
+	case q.Get("tile") != "":
+		values := strings.Split(q.Get("tile"), "/")
+		if q.Get("px") != "" || q.Get("py") != "" {
+			values = append(values, q.Get("px"), q.Get("py"))
+		}
+		return coord.ParseValues(coord.Tile, values)
+	}
+
+	return coord.Input{}, coord.ErrNoCoordinates
+}
@@ -1,30 +1,100 @@
 package server
 
 import (
-	"os"
+	"cmp"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"net/netip"
 	"path/filepath"
 	"sort"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 	"github.com/woozymasta/dzmap/assets"
+	"github.com/woozymasta/dzmap/internal/cache"
 	"github.com/woozymasta/dzmap/internal/config"
 )
 
+// Options holds runtime settings of the server that are not part of the map configuration.
+type Options struct {
+	// BasePath is the normalized path prefix the server is mounted at, see NormalizeBasePath
+	BasePath string
+	// TrustedProxies are the networks of reverse proxies whose X-Forwarded-* headers are used
+	TrustedProxies []netip.Prefix
+	// TileCacheControl is the Cache-Control header of stable tile URLs, see Revalidation
+	TileCacheControl string
+	// TileCacheSize is the in-memory tile cache budget in bytes, zero disables the cache
+	TileCacheSize int64
+}
+
+// defaultDerivedCacheSize is the budget of the cache for tiles computed by the server,
+// such as synthesized tiles, used when the tile cache is disabled.
+const defaultDerivedCacheSize = 64 << 20
+
 // ServerContext holds dependencies for request handlers.
 type ServerContext struct {
 	Config          *config.Config
 	MapNameResolver map[string]string
 	IndexHTML       []byte
+	IndexETag       string
 	Favicon         []byte
 	TransparentTile []byte
+
+	// tiles holds the opened tile sources by map name and layer
+	tiles map[string]map[string]tileSource
+	// locations holds the parsed locations.geojson by map name
+	locations map[string]*mapLocations
+	// cache is the tile cache, nil when disabled
+	cache *tileCache
+	// derived caches tiles computed by the server, it is the tile cache when enabled
+	derived *tileCache
+	// transparent holds the empty tile by format
+	transparent map[string][]byte
+	// basePath is the path prefix of public URLs
+	basePath string
+	// trustedProxies may set the public scheme and host, see baseURL
+	trustedProxies []netip.Prefix
+	// apiKeys holds the API keys by hash, see apiKeyHash
+	apiKeys map[[sha256.Size]byte]config.APIKey
+	// urlSecret is the HMAC key of signed URLs
+	urlSecret string
+	// tileCacheControl is the Cache-Control header of stable tile URLs
+	tileCacheControl string
+	// fileETags memoizes content hash ETags of files by path, see fileETag
+	fileETags sync.Map
+	// etags memoizes content hash ETags of tiles read without the tile cache, see tileETag
+	etags *cache.LRU[tileKey, fileETag]
+	// unversioned are the maps without a current manifest, see Holder.versionMaps
+	unversioned []string
+	// manifestState is the manifestState of the maps when the context was built
+	manifestState string
+
+	// inflight is read-locked by every request, see Holder
+	inflight sync.RWMutex
+	retired  bool
 }
 
 // NewServerContext initializes the context and processes the map configuration.
 // It filters out maps with missing assets and sets up the name resolver.
-func NewServerContext(cfg *config.Config) *ServerContext {
+func NewServerContext(cfg *config.Config, opts Options) *ServerContext {
 	log.Info().Int("config_maps_count", len(cfg.Maps)).Msg("Initializing server context")
 
+	// every context starts with an empty cache, or none
+	var tc *tileCache
+	if opts.TileCacheSize > 0 {
+		tc = cache.NewLRU[tileKey, cachedTile](opts.TileCacheSize)
+	}
+	tileCacheBytes.Set(0)
+
+	derived := tc
+	if derived == nil {
+		derived = cache.NewLRU[tileKey, cachedTile](defaultDerivedCacheSize)
+	}
+
 	resolver := make(map[string]string)
+	tiles := make(map[string]map[string]tileSource)
+	locations := make(map[string]*mapLocations)
 	validMaps := make([]config.Map, 0, len(cfg.Maps))
 
 	// Normalize and Sort
@@ -37,60 +107,62 @@ func NewServerContext(cfg *config.Config) *ServerContext {
 		if world.Attribution == "" {
 			world.Attribution = cfg.Attribution
 		}
+		if world.Missing == "" {
+			world.Missing = cmp.Or(cfg.Missing, config.MissingTransparent)
+		}
 
-		// Check for cache existence
-		mapBaseDir := filepath.Join("maps", world.Name)
+		// Open the layers with tiles on disk, drop the others
+		layers := make(map[string]tileSource, len(world.Layers))
+		available := make([]config.Layer, 0, len(world.Layers))
+		for _, layer := range world.Layers {
+			src := loadLayer(world.Name, layer.Name)
+			if src == nil {
+				continue
+			}
 
-		// Check Topographic
-		if world.Topographic == "" {
-			world.NoTopographic = true
-			log.Trace().
-				Str("map", world.Name).
-				Msg("Topographic layer skipped: no source in config")
-		} else {
-			topoDir := filepath.Join(mapBaseDir, "topographic")
-			if _, err := os.Stat(topoDir); os.IsNotExist(err) {
-				world.NoTopographic = true
-				log.Trace().
-					Str("map", world.Name).
-					Str("path", topoDir).
-					Msg("Topographic layer skipped: directory not found")
-			} else {
-				log.Trace().
-					Str("map", world.Name).
-					Msg("Topographic layer found")
+			if layer.ZoomLimit <= 0 {
+				layer.ZoomLimit = world.ZoomLimit
+			}
+			if layer.Attribution == "" {
+				layer.Attribution = world.Attribution
+			}
+			if layer.Missing == "" {
+				layer.Missing = world.Missing
+			}
+			if layer.Synthesize == nil {
+				layer.Synthesize = world.Synthesize
 			}
+			if world.NoFallback {
+				layer.Fallback = nil
+			}
+
+			layers[layer.Name] = withCache(src, tc, world.Name, layer.Name)
+			available = append(available, layer)
 		}
+		world.Layers = available
+		world.NoTopographic = world.Layer(config.LayerTopographic) == nil
+		world.NoSatellite = world.Layer(config.LayerSatellite) == nil
 
-		// Check Satellite
-		if world.Satellite == "" {
-			world.NoSatellite = true
-			log.Trace().
+		if len(layers) == 0 {
+			log.Warn().
 				Str("map", world.Name).
-				Msg("Satellite layer skipped: no source in config")
-		} else {
-			satDir := filepath.Join(mapBaseDir, "satellite")
-			if _, err := os.Stat(satDir); os.IsNotExist(err) {
-				world.NoSatellite = true
-				log.Trace().
-					Str("map", world.Name).
-					Str("path", satDir).
-					Msg("Satellite layer skipped: directory not found")
-			} else {
-				log.Trace().
-					Str("map", world.Name).
-					Msg("Satellite layer found")
-			}
+				Msg("Skipping map: no valid layers found")
+			continue
 		}
 
-		if world.NoTopographic && world.NoSatellite {
+		// Load locations for vector tiles and queries
+		locs, err := loadLocations(world.Name, world.Size)
+		if err != nil {
 			log.Warn().
+				Err(err).
 				Str("map", world.Name).
-				Msg("Skipping map: no valid layers found (neither topographic nor satellite)")
-			continue
+				Msg("Failed to load locations")
+		} else if locs != nil {
+			locations[world.Name] = locs
 		}
 
 		// Setup Resolver
+		tiles[world.Name] = layers
 		resolver[world.Name] = world.Name
 		for _, alias := range world.Aliases {
 			resolver[alias] = world.Name
@@ -98,8 +170,7 @@ func NewServerContext(cfg *config.Config) *ServerContext {
 
 		log.Debug().
 			Str("map", world.Name).
-			Bool("topo", !world.NoTopographic).
-			Bool("sat", !world.NoSatellite).
+			Int("layers", len(world.Layers)).
 			Msg("Map validated and added to context")
 
 		validMaps = append(validMaps, *world)
@@ -126,11 +197,75 @@ func NewServerContext(cfg *config.Config) *ServerContext {
 		Int("valid_maps_count", len(cfg.Maps)).
 		Msg("Server context initialized successfully")
 
+	index := indexWithBase(assets.Index, opts.BasePath)
+
 	return &ServerContext{
-		Config:          cfg,
-		IndexHTML:       assets.Index,
-		Favicon:         assets.Favicon,
-		TransparentTile: assets.TransparentTile,
-		MapNameResolver: resolver,
+		Config:           cfg,
+		IndexHTML:        index,
+		IndexETag:        contentETag(index),
+		Favicon:          assets.Favicon,
+		TransparentTile:  assets.TransparentTile,
+		MapNameResolver:  resolver,
+		tiles:            tiles,
+		locations:        locations,
+		cache:            tc,
+		derived:          derived,
+		transparent:      transparentTiles(assets.TransparentTile),
+		basePath:         opts.BasePath,
+		trustedProxies:   opts.TrustedProxies,
+		apiKeys:          indexAPIKeys(cfg.APIKeys),
+		urlSecret:        cfg.URLSecret,
+		tileCacheControl: cmp.Or(opts.TileCacheControl, "public, no-cache"),
+		etags:            cache.NewLRU[tileKey, fileETag](etagCacheSize),
+		manifestState:    manifestState(cfg.Maps),
+	}
+}
+
+// Close releases the tile sources opened by the context.
+func (s *ServerContext) Close() error {
+	var errs []error
+	for _, layers := range s.tiles {
+		for _, src := range layers {
+			errs = append(errs, src.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// withCache wraps the source with the tile cache if caching is enabled.
+func withCache(src tileSource, tc *tileCache, mapName, layer string) tileSource {
+	if tc == nil {
+		return src
 	}
+
+	return &cachedSource{src: src, cache: tc, mapName: mapName, layer: layer}
+}
+
+// loadLayer opens a map layer and logs why it was skipped if it is unavailable.
+func loadLayer(mapName, layer string) tileSource {
+	src, err := openLayer(mapName, layer)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Trace().
+			Str("map", mapName).
+			Str("layer", layer).
+			Str("path", filepath.Join("maps", mapName)).
+			Msg("Layer skipped: neither directory nor tile archive found")
+		return nil
+	}
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("map", mapName).
+			Str("layer", layer).
+			Msg("Layer skipped: failed to open tiles")
+		return nil
+	}
+
+	log.Trace().
+		Str("map", mapName).
+		Str("layer", layer).
+		Msg("Layer found")
+
+	return src
 }
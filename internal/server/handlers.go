@@ -2,22 +2,27 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
+	"html"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
-)
+	"time"
+
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/render"
 
-const etagCap = 64
+	"github.com/rs/zerolog/log"
+)
 
 // HandleMapsList serves the JSON configuration of available maps.
 func (s *ServerContext) HandleMapsList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	// Ignoring error as we cannot handle client disconnects
-	_ = json.NewEncoder(w).Encode(s.Config.Maps)
+	_ = json.NewEncoder(w).Encode(s.visibleMaps(r))
 }
 
 // HandleFavicon serves the site favicon.
@@ -39,22 +44,44 @@ func (s *ServerContext) HandleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	etag := fmt.Sprintf(`"%x"`, len(s.IndexHTML))
-
-	if match := r.Header.Get("If-None-Match"); match == etag {
+	if match := r.Header.Get("If-None-Match"); match == s.IndexETag {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("ETag", etag)
+	w.Header().Set("ETag", s.IndexETag)
 	w.Header().Set("Cache-Control", "public, no-cache")
 	_, _ = w.Write(s.IndexHTML)
 }
 
+// indexWithBase sets the <base href> of the index page to the base path,
+// the page script resolves all its URLs against it.
+func indexWithBase(index []byte, basePath string) []byte {
+	start := bytes.Index(index, []byte("<base href="))
+	if start < 0 {
+		return index
+	}
+	end := bytes.IndexByte(index[start:], '>')
+	if end < 0 {
+		return index
+	}
+	end += start
+
+	tag := `<base href="` + html.EscapeString(basePath+"/") + `">`
+	out := make([]byte, 0, len(index)+len(tag))
+	out = append(out, index[:start]...)
+	out = append(out, tag...)
+	out = append(out, index[end+1:]...)
+
+	return out
+}
+
 // HandleTileOrLoc serves static assets (tiles and GeoJSON) for specific maps.
 func (s *ServerContext) HandleTileOrLoc(w http.ResponseWriter, r *http.Request) {
-	// Path: /maps/{mapName}/...
+	start := time.Now()
+
+	// Path: /maps/{mapName}[@{version}]/...
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 
 	if len(parts) < 3 {
@@ -62,63 +89,243 @@ func (s *ServerContext) HandleTileOrLoc(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	requestedName := parts[1]
-	realMapName, ok := s.MapNameResolver[requestedName]
+	requestedName, version := splitVersion(parts[1])
+	realMapName, ok := s.resolveMap(w, r, requestedName)
 	if !ok {
+		if len(parts) >= 6 {
+			observeTile("", "", -1, tileResultNotFound, start)
+		}
 		http.NotFound(w, r)
 		return
 	}
 
+	// Stored files and tiles of versioned URLs of the current content never change,
+	// outdated versions and responses the version doesn't cover, such as fallback
+	// or missing tiles, get the policy of stable URLs
+	storedCacheControl := s.tileCacheControl
+	if world := s.findMap(realMapName); version != "" && world != nil && version == world.Version {
+		storedCacheControl = immutableCacheControl
+	}
+
 	// GeoJSON
 	if len(parts) == 3 && parts[2] == "locations.geojson" {
 		path := filepath.Join("maps", realMapName, "locations.geojson")
-		s.serveFile(w, r, path, "application/geo+json")
+		s.serveFile(w, r, path, "application/geo+json", storedCacheControl)
 		return
 	}
 
-	// WebP Tile
-	if len(parts) >= 6 {
-		// parts: maps, mapName, layer, z, x, y.webp
-		layer, z, x, y := parts[2], parts[3], parts[4], parts[5]
+	// PMTiles archive, clients read it with HTTP Range requests
+	if layer, ok := strings.CutSuffix(parts[2], ".pmtiles"); len(parts) == 3 && ok && s.hasLayer(realMapName, layer) {
+		path := filepath.Join("maps", realMapName, parts[2])
+		if !s.serveFile(w, r, path, "application/vnd.pmtiles", storedCacheControl) {
+			http.NotFound(w, r)
+		}
+		return
+	}
 
-		// allow only known layers to prevent path probing
-		if layer != "topographic" && layer != "satellite" {
+	// TileJSON
+	if len(parts) == 3 && strings.HasSuffix(parts[2], ".json") {
+		layer, hidpi := strings.CutSuffix(strings.TrimSuffix(parts[2], ".json"), hidpiSuffix)
+		world := s.findMap(realMapName)
+		if world != nil && !hidpi && layer == "locations" && s.locations[realMapName] != nil {
+			s.serveLocationsTileJSON(w, r, world)
+			return
+		}
+		if world == nil || !s.hasLayer(realMapName, layer) {
 			http.NotFound(w, r)
 			return
 		}
+		s.serveTileJSON(w, r, world, layer, hidpi)
+		return
+	}
 
-		tryServe := func(l string) bool {
-			path := filepath.Join("maps", realMapName, l, z, x, y)
-			return s.serveFile(w, r, path, "")
+	// Vector tile with locations
+	if len(parts) == 6 && parts[2] == "locations" {
+		z, x, y, ok := parseTileCoords(parts[3], parts[4], parts[5], ".mvt")
+		if !ok {
+			http.NotFound(w, r)
+			return
 		}
+		s.serveLocationsTile(w, r, realMapName, z, x, y)
+		return
+	}
+
+	// Raster Tile
+	if len(parts) >= 6 {
+		// parts: maps, mapName, layer, z, x, y[@2x].{webp,png,jpg}
+		layer := parts[2]
 
-		// try requested layer
-		if tryServe(layer) {
+		// allow only known layers to prevent path probing
+		if !s.hasLayer(realMapName, layer) {
+			observeTile(realMapName, "", -1, tileResultNotFound, start)
+			http.NotFound(w, r)
 			return
 		}
 
-		// fallback to the other layer
-		alt := "satellite"
-		if layer == "satellite" {
-			alt = "topographic"
-		}
-		if tryServe(alt) {
+		ext := path.Ext(parts[5])
+		format, negotiated, okFormat := tileFormat(ext, r.Header.Get("Accept"))
+		ys, hidpi := strings.CutSuffix(strings.TrimSuffix(parts[5], ext), hidpiSuffix)
+		z, x, y, ok := parseTileCoords(parts[3], parts[4], ys, "")
+		if !ok || !okFormat {
+			observeTile(realMapName, layer, -1, tileResultNotFound, start)
+			http.NotFound(w, r)
 			return
 		}
+		if negotiated {
+			w.Header().Add("Vary", "Accept")
+		}
 
-		// cache transparent tile
-		w.Header().Set("Content-Type", "image/webp")
-		w.Header().Set("Cache-Control", "public, max-age=3600")
-		_, _ = w.Write(s.TransparentTile)
+		result := s.serveLayerTile(w, r, realMapName, layer, z, x, y, format, hidpi, storedCacheControl)
+		observeTile(realMapName, layer, z, result, start)
 		return
 	}
 
 	http.NotFound(w, r)
 }
 
-// serveFile tries to serve a file from disk with ETag generation.
+// serveLayerTile serves a tile of the map layer in the given format, falling back
+// to its fallback layers, then to a tile synthesized from its ancestor or children
+// and finally to the missing tile response of the layer. With hidpi the 512px
+// tile stitched from the children is served instead.
+// The result is sent in the X-Tile-Result header, the layer a fallback tile
+// comes from in X-Tile-Fallback. storedCacheControl is the Cache-Control
+// header of tiles stored in the layer, all others get the stable URL policy.
+// It returns the result for metrics.
+func (s *ServerContext) serveLayerTile(w http.ResponseWriter, r *http.Request, mapName, layer string, z, x, y int, format string, hidpi bool, storedCacheControl string) string {
+	key := tileKey{mapName: mapName, layer: layer, z: z, x: x, y: y}
+
+	var (
+		t        tile
+		result   string
+		fallback string
+		ok       bool
+	)
+	if hidpi {
+		key.variant = variantHiDPI
+		t, ok = s.resolveHiDPITile(mapName, layer, z, x, y)
+		result = tileResultStitched
+	} else {
+		t, result, fallback, ok = s.resolveTile(mapName, layer, z, x, y)
+	}
+
+	if ok {
+		if t, ok = s.transcodeTile(key, t, format); ok {
+			w.Header().Set(tileResultHeader, result)
+			if fallback != "" {
+				w.Header().Set(tileFallbackHeader, fallback)
+			}
+			cacheControl := s.tileCacheControl
+			if result == tileResultFile {
+				cacheControl = storedCacheControl
+			}
+			if s.serveTile(w, r, key, t, format, cacheControl) {
+				return tileResultNotModified
+			}
+			return result
+		}
+	}
+
+	return s.serveMissingTile(w, r, mapName, layer, format)
+}
+
+// serveMissingTile answers a request for a tile missing in the map layer as
+// configured by its missing setting, a transparent tile by default.
+// It returns the result for metrics.
+func (s *ServerContext) serveMissingTile(w http.ResponseWriter, r *http.Request, mapName, layer, format string) string {
+	missing := config.MissingTransparent
+	if l := s.findLayer(mapName, layer); l != nil && l.Missing != "" {
+		missing = l.Missing
+	}
+
+	setCacheControl(w, "public, max-age=3600")
+
+	switch missing {
+	case config.MissingNotFound:
+		w.Header().Set(tileResultHeader, tileResultNotFound)
+		http.NotFound(w, r)
+		return tileResultNotFound
+
+	case config.MissingNoContent:
+		w.Header().Set(tileResultHeader, tileResultNoContent)
+		w.WriteHeader(http.StatusNoContent)
+		return tileResultNoContent
+
+	default:
+		// cache transparent tile
+		w.Header().Set(tileResultHeader, tileResultTransparent)
+		w.Header().Set("Content-Type", render.ContentType(format))
+		_, _ = w.Write(s.transparent[format])
+		return tileResultTransparent
+	}
+}
+
+// resolveTile returns a stored tile of the map layer or of its fallback layers,
+// or one synthesized from its ancestor or children, with the result for metrics
+// and the name of the fallback layer the tile comes from.
+func (s *ServerContext) resolveTile(mapName, layer string, z, x, y int) (tile, string, string, bool) {
+	if t, fallback, ok := s.lookupTile(mapName, layer, z, x, y); ok {
+		if fallback != "" {
+			return t, tileResultFallback, fallback, true
+		}
+		return t, tileResultFile, "", true
+	}
+
+	if l := s.findLayer(mapName, layer); l != nil && l.Synthesizes() {
+		if t, ok := s.synthesizeTile(mapName, layer, z, x, y); ok {
+			return t, tileResultSynthesized, "", true
+		}
+	}
+
+	return tile{}, "", "", false
+}
+
+// lookupTile returns a tile of the map layer, falling back to the layers in
+// its fallback list in order. fallback is the name of the layer the tile
+// comes from when it is not the requested one.
+// Read errors are logged and treated as a missing tile.
+func (s *ServerContext) lookupTile(mapName, layer string, z, x, y int) (t tile, fallback string, ok bool) {
+	layers := s.tiles[mapName]
+	read := func(l string) (tile, bool) {
+		src, ok := layers[l]
+		if !ok {
+			return tile{}, false
+		}
+
+		t, ok, err := src.Tile(z, x, y)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("map", mapName).
+				Str("layer", l).
+				Msg("Failed to read tile")
+			return tile{}, false
+		}
+
+		return t, ok
+	}
+
+	// try requested layer
+	if t, ok := read(layer); ok {
+		return t, "", true
+	}
+
+	// fallback layers
+	if l := s.findLayer(mapName, layer); l != nil {
+		for _, alt := range l.Fallback {
+			if t, ok := read(alt); ok {
+				return t, alt, true
+			}
+		}
+	}
+
+	return tile{}, "", false
+}
+
+// serveFile tries to serve a file from disk with a content hash ETag and the
+// given Cache-Control header. Files larger than fileHashLimit, such as PMTiles
+// archives, get a weak ETag from their size and modification time instead.
 // It returns true if the file was found and served (or 304).
-func (s *ServerContext) serveFile(w http.ResponseWriter, r *http.Request, path string, contentType string) bool {
+func (s *ServerContext) serveFile(w http.ResponseWriter, r *http.Request, path, contentType, cacheControl string) bool {
 	info, err := os.Stat(path)
 	if err != nil {
 		return false
@@ -127,13 +334,13 @@ func (s *ServerContext) serveFile(w http.ResponseWriter, r *http.Request, path s
 		return false
 	}
 
-	buf := make([]byte, 0, etagCap)
-	buf = append(buf, '"')
-	buf = strconv.AppendInt(buf, info.Size(), 16)
-	buf = append(buf, '-')
-	buf = strconv.AppendInt(buf, info.ModTime().UnixNano(), 16)
-	buf = append(buf, '"')
-	etag := string(buf)
+	etag := weakETag(info)
+	if info.Size() <= fileHashLimit {
+		if etag, err = s.fileETag(path, info); err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to hash file")
+			return false
+		}
+	}
 
 	// check If-None-Match (client sent ETag)
 	if match := r.Header.Get("If-None-Match"); match == etag {
@@ -142,7 +349,7 @@ func (s *ServerContext) serveFile(w http.ResponseWriter, r *http.Request, path s
 	}
 
 	w.Header().Set("ETag", etag)
-	w.Header().Set("Cache-Control", "public, no-cache")
+	setCacheControl(w, cacheControl)
 
 	if contentType != "" {
 		w.Header().Set("Content-Type", contentType)
@@ -151,3 +358,29 @@ func (s *ServerContext) serveFile(w http.ResponseWriter, r *http.Request, path s
 	http.ServeFile(w, r, path)
 	return true
 }
+
+// serveTile writes an in-memory tile with the same caching headers as serveFile.
+// It returns true if the client copy was still valid and 304 was sent.
+func (s *ServerContext) serveTile(w http.ResponseWriter, r *http.Request, key tileKey, t tile, format, cacheControl string) bool {
+	etag := s.tileETag(key, t)
+	variant := key.variant
+	if format != render.FormatWebP {
+		variant += "-" + format
+	}
+	if variant != "" {
+		// derived tiles never share a validator with the stored one
+		etag = etag[:len(etag)-1] + "-" + strings.TrimPrefix(variant, "-") + `"`
+	}
+
+	if match := r.Header.Get("If-None-Match"); match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	w.Header().Set("ETag", etag)
+	setCacheControl(w, cacheControl)
+	w.Header().Set("Content-Type", render.ContentType(format))
+
+	http.ServeContent(w, r, "", t.ModTime, bytes.NewReader(t.Data))
+	return false
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS holds the cross-origin resource sharing options of the server.
+type CORS struct {
+	Origins []string `long:"cors-origin" env:"CORS_ORIGINS" env-delim:"," description:"Origin allowed to make cross-origin requests, * allows any (repeatable)"`
+	//nolint:staticcheck // allow duplicate struct tags
+	Methods []string `long:"cors-method" env:"CORS_METHODS" env-delim:"," description:"Method allowed in cross-origin requests (repeatable)" default:"GET" default:"HEAD" default:"POST" default:"OPTIONS"`
+	//nolint:staticcheck // allow duplicate struct tags
+	Headers []string      `long:"cors-header" env:"CORS_HEADERS" env-delim:"," description:"Request header allowed in cross-origin requests (repeatable)" default:"Content-Type" default:"If-None-Match" default:"Range" default:"X-API-Key"`
+	MaxAge  time.Duration `long:"cors-max-age" env:"CORS_MAX_AGE" description:"How long browsers may cache preflight responses" default:"1h"`
+}
+
+// corsExposedHeaders are the response headers readable by cross-origin scripts,
+// PMTiles clients need them for Range requests.
+const corsExposedHeaders = "ETag, Content-Length, Content-Range, Accept-Ranges, " +
+	tileResultHeader + ", " + tileFallbackHeader
+
+// Handler wraps next with CORS headers for allowed origins and answers preflight requests.
+// Without configured origins requests pass through unchanged.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	if len(c.Origins) == 0 {
+		return next
+	}
+
+	anyOrigin := slices.Contains(c.Origins, "*")
+	methods := strings.Join(c.Methods, ", ")
+	headers := strings.Join(c.Headers, ", ")
+	maxAge := strconv.Itoa(int(c.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !anyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin == "" || (!anyOrigin && !slices.Contains(c.Origins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		// preflight
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SecurityHeaders is a middleware setting response headers that harden browsers
+// against content sniffing, referrer leaks and, unless allowFraming, clickjacking.
+func SecurityHeaders(next http.Handler, allowFraming bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if !allowFraming {
+			h.Set("X-Frame-Options", "SAMEORIGIN")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NormalizeBasePath cleans the path prefix the server is mounted at, returning it
+// with a leading and without a trailing slash, or empty for the root.
+func NormalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+
+	return "/" + p
+}
+
+// MountAt serves next under the base path, stripping it from request paths.
+// The base path itself redirects to the index with a trailing slash.
+func MountAt(basePath string, next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(basePath+"/", http.StripPrefix(basePath, next))
+	mux.Handle(basePath, http.RedirectHandler(basePath+"/", http.StatusMovedPermanently))
+
+	return mux
+}
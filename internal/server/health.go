@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ReadyStatus is the response of the readiness endpoint.
+type ReadyStatus struct {
+	Status     string `json:"status"`
+	MapsLoaded int    `json:"maps_loaded"`
+}
+
+// HandleHealth reports that the process is alive and serving requests.
+func (h *Holder) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// HandleReady reports whether the server should receive traffic: at least one
+// map is loaded and the server is not shutting down.
+func (h *Holder) HandleReady(w http.ResponseWriter, r *http.Request) {
+	status := ReadyStatus{Status: "ready", MapsLoaded: len(h.Current().Config.Maps)}
+	code := http.StatusOK
+
+	switch {
+	case h.draining.Load():
+		status.Status = "draining"
+		code = http.StatusServiceUnavailable
+	case status.MapsLoaded == 0:
+		status.Status = "no maps loaded"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// Drain marks the server as shutting down, failing readiness checks
+// so load balancers stop sending new requests.
+func (h *Holder) Drain() {
+	h.draining.Store(true)
+}
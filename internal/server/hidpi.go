@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"time"
+
+	"github.com/chai2010/webp"
+	"github.com/rs/zerolog/log"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// hidpiSuffix marks high-DPI tiles in tile URLs, as in {y}@2x.webp
+	hidpiSuffix = "@2x"
+	// variantHiDPI marks stitched high-DPI tiles in the tile cache
+	variantHiDPI = "@2x"
+)
+
+// resolveHiDPITile returns a 512px tile stitched from the four children of the tile
+// at z+1. Children are resolved like regular tiles, so missing ones fall back to the
+// other layer or are synthesized; the result is cached.
+func (s *ServerContext) resolveHiDPITile(mapName, layer string, z, x, y int) (tile, bool) {
+	if z >= 30 || x >= 1<<z || y >= 1<<z {
+		return tile{}, false
+	}
+
+	return s.derivedTile(tileKey{mapName: mapName, layer: layer, variant: variantHiDPI, z: z, x: x, y: y}, func() (tile, bool) {
+		dst := image.NewRGBA(image.Rect(0, 0, tileSize*2, tileSize*2))
+		var modTime time.Time
+		found := false
+
+		for i := range 4 {
+			t, _, _, ok := s.resolveTile(mapName, layer, z+1, x*2+i%2, y*2+i/2)
+			if !ok {
+				continue
+			}
+
+			child, ok := s.decodeTile(t, mapName, layer)
+			if !ok {
+				continue
+			}
+
+			quadrant := image.Rect(0, 0, tileSize, tileSize).Add(image.Pt(i%2*tileSize, i/2*tileSize))
+			if child.Bounds().Dx() == tileSize && child.Bounds().Dy() == tileSize {
+				draw.Draw(dst, quadrant, child, child.Bounds().Min, draw.Src)
+			} else {
+				xdraw.BiLinear.Scale(dst, quadrant, child, child.Bounds(), draw.Src, nil)
+			}
+
+			if t.ModTime.After(modTime) {
+				modTime = t.ModTime
+			}
+			found = true
+		}
+		if !found {
+			return tile{}, false
+		}
+
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, dst, &webp.Options{Lossless: false, Quality: synthQuality}); err != nil {
+			log.Error().Err(err).Str("map", mapName).Str("layer", layer).Msg("Failed to encode high-DPI tile")
+			return tile{}, false
+		}
+
+		return tile{Data: buf.Bytes(), ModTime: modTime}, true
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/woozymasta/dzmap/internal/geo"
+	"github.com/woozymasta/dzmap/internal/mvt"
+)
+
+const (
+	// mvtMaxZoom is the deepest zoom level vector tiles are generated for
+	mvtMaxZoom = 22
+	// mvtBuffer keeps points slightly outside the tile so labels are not cut at tile edges
+	mvtBuffer = 64
+	// mvtGrid is the size in extent units of a cell that holds at most one point
+	mvtGrid = mvt.DefaultExtent / 256
+)
+
+// mapLocations holds the parsed locations.geojson of a map.
+type mapLocations struct {
+	Features []geo.GeoJSONFeature
+	index    []searchEntry
+	grid     *spatialGrid
+}
+
+// loadLocations reads maps/{name}/locations.geojson into memory.
+// The spatial index is only built when the map size is known.
+// It returns nil without error if the map has no locations file.
+func loadLocations(mapName string, mapSize int) (*mapLocations, error) {
+	path := filepath.Join("maps", mapName, "locations.geojson")
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fc geo.GeoJSONFeatureCollection
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, err
+	}
+
+	locs := &mapLocations{
+		Features: fc.Features,
+		index:    buildSearchIndex(fc.Features),
+	}
+	if mapSize > 0 {
+		locs.grid = newSpatialGrid(fc.Features, float64(mapSize))
+	}
+
+	return locs, nil
+}
+
+// serveLocationsTile generates a Mapbox Vector Tile with the locations inside the tile.
+// Points closer than one pixel are thinned out so low zoom levels stay light,
+// the most important location types win over the rest.
+func (s *ServerContext) serveLocationsTile(w http.ResponseWriter, r *http.Request, mapName string, z, x, y int) {
+	locs := s.locations[mapName]
+	if locs == nil || z > mvtMaxZoom || x >= 1<<z || y >= 1<<z {
+		http.NotFound(w, r)
+		return
+	}
+
+	candidates := s.tileLocations(locs, mapName, z, x, y)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ti, _ := locs.Features[candidates[i]].Properties["type"].(string)
+		tj, _ := locs.Features[candidates[j]].Properties["type"].(string)
+		return minZoomForType(ti) < minZoomForType(tj)
+	})
+
+	layer := mvt.Layer{Name: "locations", Extent: mvt.DefaultExtent}
+	occupied := make(map[[2]int32]bool)
+
+	for _, i := range candidates {
+		f := locs.Features[i]
+		if f.Geometry.Type != "Point" || len(f.Geometry.Coordinates) < 2 {
+			continue
+		}
+
+		tx, ty := geo.LonLatToTile(f.Geometry.Coordinates[0], f.Geometry.Coordinates[1], z)
+		fx := math.Floor((tx - float64(x)) * mvt.DefaultExtent)
+		fy := math.Floor((ty - float64(y)) * mvt.DefaultExtent)
+
+		// clip to the tile and its buffer
+		if fx < -mvtBuffer || fy < -mvtBuffer || fx > mvt.DefaultExtent+mvtBuffer || fy > mvt.DefaultExtent+mvtBuffer {
+			continue
+		}
+		px, py := int32(fx), int32(fy)
+
+		// simplify: keep the most important point of every grid cell
+		cell := [2]int32{int32(math.Floor(fx / mvtGrid)), int32(math.Floor(fy / mvtGrid))}
+		if occupied[cell] {
+			continue
+		}
+		occupied[cell] = true
+
+		props := make(map[string]any, 2)
+		if name, ok := f.Properties["name"].(string); ok {
+			props["name"] = name
+		}
+		if typ, ok := f.Properties["type"].(string); ok {
+			props["type"] = typ
+		}
+
+		layer.Features = append(layer.Features, mvt.Feature{
+			ID:         uint64(i + 1),
+			X:          px,
+			Y:          py,
+			Properties: props,
+		})
+	}
+
+	data := mvt.Encode(layer)
+	if len(data) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	etag := contentETag(data)
+	if match := r.Header.Get("If-None-Match"); match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	setCacheControl(w, s.tileCacheControl)
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	_, _ = w.Write(data)
+}
+
+// tileLocations returns the indexes of the features that may fall inside the tile and its buffer.
+// Without a spatial index, when the map size is unknown, every feature is a candidate.
+func (s *ServerContext) tileLocations(locs *mapLocations, mapName string, z, x, y int) []int {
+	world := s.findMap(mapName)
+	if locs.grid == nil || world == nil || world.Size <= 0 {
+		all := make([]int, len(locs.Features))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	size := float64(world.Size)
+	buffer := float64(mvtBuffer) / mvt.DefaultExtent
+	minX, maxZ := geo.TileToGame(float64(x)-buffer, float64(y)-buffer, size, z)
+	maxX, minZ := geo.TileToGame(float64(x+1)+buffer, float64(y+1)+buffer, size, z)
+
+	// LonLatToTile clamps latitudes beyond the Mercator limit onto the top and bottom rows
+	if y == 0 {
+		maxZ = math.Inf(1)
+	}
+	if y == 1<<z-1 {
+		minZ = math.Inf(-1)
+	}
+
+	points := locs.grid.within(minX, minZ, maxX, maxZ)
+	indexes := make([]int, len(points))
+	for i, p := range points {
+		indexes[i] = p.feature
+	}
+	// keep the file order among locations of the same type
+	sort.Ints(indexes)
+
+	return indexes
+}
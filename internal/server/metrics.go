@@ -0,0 +1,104 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/woozymasta/dzmap/internal/metrics"
+)
+
+// Results of a tile request as reported in metrics.
+const (
+	tileResultFile        = "file"
+	tileResultNotModified = "not_modified"
+	tileResultFallback    = "fallback"
+	tileResultSynthesized = "synthesized"
+	tileResultStitched    = "stitched"
+	tileResultTransparent = "transparent"
+	tileResultNotFound    = "not_found"
+	tileResultNoContent   = "no_content"
+)
+
+// Headers of tile responses reporting how the tile was resolved.
+const (
+	// tileResultHeader carries the tile result as reported in metrics
+	tileResultHeader = "X-Tile-Result"
+	// tileFallbackHeader names the fallback layer a tile was served from
+	tileFallbackHeader = "X-Tile-Fallback"
+)
+
+var (
+	tileRequests = metrics.NewCounterVec(
+		"dzmap_tile_requests",
+		"Tile requests by map, layer, zoom and result.",
+		"map", "layer", "zoom", "result")
+
+	tileDuration = metrics.NewHistogramVec(
+		"dzmap_tile_request_duration_seconds",
+		"Tile request latency by map, layer, zoom and result.",
+		metrics.DefaultBuckets,
+		"map", "layer", "zoom", "result")
+
+	rateLimited = metrics.NewCounterVec(
+		"dzmap_rate_limited_requests",
+		"Requests rejected by the rate limiter by budget.",
+		"class")
+
+	tileCacheRequests = metrics.NewCounterVec(
+		"dzmap_tile_cache_requests",
+		"Tile cache lookups by result.",
+		"result")
+
+	tileCacheBytes = metrics.NewGauge(
+		"dzmap_tile_cache_bytes",
+		"Approximate size of the tile cache.")
+
+	mapsLoaded = metrics.NewGauge(
+		"dzmap_maps_loaded",
+		"Number of maps with at least one available layer.")
+
+	configReloads = metrics.NewCounterVec(
+		"dzmap_config_reloads",
+		"Configuration load attempts by result, including the initial load.",
+		"result")
+
+	configReloadSuccess = metrics.NewGauge(
+		"dzmap_config_last_reload_success",
+		"Whether the last configuration reload succeeded.")
+
+	configReloadTime = metrics.NewGauge(
+		"dzmap_config_last_reload_success_timestamp_seconds",
+		"Unix time of the last successful configuration load.")
+)
+
+// observeTile records a finished tile request.
+// Unknown maps and layers are reported as "unknown" to bound label cardinality.
+func observeTile(mapName, layer string, z int, result string, start time.Time) {
+	zoom := ""
+	if z >= 0 && z <= 30 {
+		zoom = strconv.Itoa(z)
+	}
+	if mapName == "" {
+		mapName = "unknown"
+	}
+	if layer == "" {
+		layer = "unknown"
+	}
+
+	tileRequests.Inc(mapName, layer, zoom, result)
+	tileDuration.Observe(time.Since(start).Seconds(), mapName, layer, zoom, result)
+}
+
+// recordLoad updates the configuration gauges after a load attempt.
+func recordLoad(srvCtx *ServerContext, err error) {
+	if err != nil {
+		configReloads.Inc("failure")
+		configReloadSuccess.Set(0)
+		return
+	}
+
+	configReloads.Inc("success")
+	configReloadSuccess.Set(1)
+	configReloadTime.Set(float64(time.Now().Unix()))
+	mapsLoaded.Set(float64(len(srvCtx.Config.Maps)))
+}
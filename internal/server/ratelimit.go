@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateSweepInterval is how often buckets of idle clients are dropped.
+const rateSweepInterval = time.Minute
+
+// Request classes with separate rate limit budgets.
+const (
+	rateClassTiles = "tiles"
+	rateClassAPI   = "api"
+)
+
+// RateLimit holds the per-client rate limiting options.
+// Tiles (including WMTS) and the JSON APIs have separate token buckets,
+// other routes such as the viewer page and probes are not limited.
+type RateLimit struct {
+	//nolint:staticcheck // allow duplicate struct tags
+	By        string   `long:"rate-limit-by"    env:"RATE_LIMIT_BY"                 description:"Identify clients by IP or by API key, falling back to IP"                    default:"ip" choice:"ip" choice:"key"`
+	Keys      []string `long:"rate-limit-key"   env:"RATE_LIMIT_KEYS" env-delim:"," description:"API key with its own budget when clients are identified by key (repeatable)"`
+	TileRate  float64  `long:"rate-tiles"       env:"RATE_TILES"                    description:"Tile requests per second per client, 0 disables"`
+	TileBurst int      `long:"rate-tiles-burst" env:"RATE_TILES_BURST"              description:"Tile requests a client can make at once"                                     default:"200"`
+	APIRate   float64  `long:"rate-api"         env:"RATE_API"                      description:"API requests per second per client, 0 disables"`
+	APIBurst  int      `long:"rate-api-burst"   env:"RATE_API_BURST"                description:"API requests a client can make at once"                                      default:"20"`
+}
+
+// Handler wraps next with the rate limiter. It expects paths without the base path.
+// Only the configured keys and keys accepted by validKey (may be nil) identify
+// clients, others fall back to the IP so made up keys can't be used to get
+// fresh budgets. The client IP is taken from X-Forwarded-For of requests from proxies.
+// Without any rate set requests pass through unchanged.
+func (l *RateLimit) Handler(next http.Handler, validKey func(string) bool, proxies []netip.Prefix) http.Handler {
+	if l.TileRate <= 0 && l.APIRate <= 0 {
+		return next
+	}
+
+	limiters := map[string]*rateLimiter{
+		rateClassTiles: newRateLimiter(l.TileRate, l.TileBurst),
+		rateClassAPI:   newRateLimiter(l.APIRate, l.APIBurst),
+	}
+	byKey := l.By == "key"
+	known := func(key string) bool {
+		return slices.Contains(l.Keys, key) || (validKey != nil && validKey(key))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := rateClass(r.URL.Path)
+		limiter := limiters[class]
+		if limiter == nil || limiter.rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := ""
+		if byKey {
+			if key := requestAPIKey(r); key != "" && known(key) {
+				client = "key:" + key
+			}
+		}
+		if client == "" {
+			client = "ip:" + clientIP(r, proxies)
+		}
+
+		if wait, ok := limiter.allow(client, time.Now()); !ok {
+			rateLimited.Inc(class)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateClass returns the budget a request path counts against, empty for unlimited routes.
+func rateClass(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/maps/"), path == "/wmts", strings.HasPrefix(path, "/wmts/"):
+		return rateClassTiles
+	case strings.HasPrefix(path, "/api/"):
+		return rateClassAPI
+	default:
+		return ""
+	}
+}
+
+// requestAPIKey returns the API key sent in the X-API-Key header or the api_key query parameter.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	return r.URL.Query().Get("api_key")
+}
+
+// clientIP returns the address of the client. When the connection comes from a
+// trusted proxy, X-Forwarded-For is walked from the right and the first address
+// that is not a trusted proxy is used.
+func clientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !trusted(addr, proxies) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !trusted(addr, proxies) {
+			break
+		}
+	}
+
+	return addr.String()
+}
+
+// trusted reports whether addr belongs to one of the trusted proxy networks.
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTrustedProxies parses the IPs and CIDRs of trusted reverse proxies.
+func ParseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	proxies := make([]netip.Prefix, 0, len(list))
+	for _, p := range list {
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", p, err)
+		}
+		proxies = append(proxies, prefix)
+	}
+
+	return proxies, nil
+}
+
+// fromTrustedProxy reports whether the request connection comes from a trusted proxy.
+func fromTrustedProxy(r *http.Request, proxies []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	return err == nil && trusted(addr, proxies)
+}
+
+// parsePrefix parses a CIDR or a single IP address as a network prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// tokenBucket is the state of one client, tokens are refilled lazily on access.
+type tokenBucket struct {
+	last   time.Time
+	tokens float64
+}
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+}
+
+// newRateLimiter creates a limiter refilling rate tokens per second up to burst.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+	}
+}
+
+// allow takes a token from the bucket of client. When it is empty it returns
+// how long until the next token is available.
+func (l *rateLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+
+	return 0, true
+}
+
+// sweep drops the buckets that have refilled completely, they are the same as new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
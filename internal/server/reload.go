@@ -0,0 +1,186 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/woozymasta/dzmap/internal/config"
+)
+
+// LoadFunc builds a fresh ServerContext, typically from the configuration file.
+type LoadFunc func() (*ServerContext, error)
+
+// Holder keeps the active ServerContext and replaces it atomically on reload.
+// Requests already in flight finish against the context they started with,
+// the old context is closed once they are done.
+type Holder struct {
+	current  atomic.Pointer[ServerContext]
+	load     LoadFunc
+	mu       sync.Mutex  // serializes reloads
+	draining atomic.Bool // set on shutdown, see Drain
+	hashing  atomic.Bool // set while manifests are built, see buildManifests
+
+	// built holds the manifests hashed by the server by map directory,
+	// for map directories it can't write them to, see buildManifest
+	built sync.Map
+}
+
+// NewHolder builds the initial context with load.
+func NewHolder(load LoadFunc) (*Holder, error) {
+	srvCtx, err := load()
+	recordLoad(srvCtx, err)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Holder{load: load}
+	h.versionMaps(srvCtx, nil)
+	h.current.Store(srvCtx)
+	h.buildManifests(srvCtx)
+
+	return h, nil
+}
+
+// Current returns the active context.
+func (h *Holder) Current() *ServerContext {
+	return h.current.Load()
+}
+
+// Handler adapts a ServerContext method expression to an http.HandlerFunc
+// that always runs against the active context.
+func (h *Holder) Handler(fn func(*ServerContext, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srvCtx := h.acquire()
+		defer srvCtx.inflight.RUnlock()
+
+		fn(srvCtx, w, r)
+	}
+}
+
+// Reload builds a new context and swaps it in.
+// On error the running context stays active.
+func (h *Holder) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start := time.Now()
+	srvCtx, err := h.load()
+	recordLoad(srvCtx, err)
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration reload failed, keeping current configuration")
+		return err
+	}
+
+	h.versionMaps(srvCtx, h.current.Load())
+	old := h.current.Swap(srvCtx)
+	go retire(old)
+	h.buildManifests(srvCtx)
+
+	log.Info().
+		Int("maps_loaded", len(srvCtx.Config.Maps)).
+		Dur("duration", time.Since(start)).
+		Msg("Configuration reloaded")
+
+	return nil
+}
+
+// Watch polls the file at path and reloads when its size or modification time changes,
+// or when the manifest of a map changes, e.g. written by the loader after a rebuild.
+// It returns when stop is closed.
+func (h *Holder) Watch(path string, interval time.Duration, stop <-chan struct{}) {
+	last, _ := os.Stat(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to stat configuration file")
+			continue
+		}
+		if last == nil || info.Size() != last.Size() || !info.ModTime().Equal(last.ModTime()) {
+			last = info
+			log.Info().Str("path", path).Msg("Configuration file changed, reloading")
+			_ = h.Reload()
+			continue
+		}
+
+		srvCtx := h.Current()
+		if manifestState(srvCtx.Config.Maps) != srvCtx.manifestState {
+			log.Info().Msg("Map manifest changed, reloading")
+			_ = h.Reload()
+		}
+	}
+}
+
+// buildManifests hashes in the background the files of the maps of the context
+// without a current manifest, then reloads to serve them under versioned URLs.
+// Only one build runs at a time, the reload starts the next one if needed.
+func (h *Holder) buildManifests(srvCtx *ServerContext) {
+	if len(srvCtx.unversioned) == 0 || !h.hashing.CompareAndSwap(false, true) {
+		return
+	}
+
+	worlds := make([]config.Map, 0, len(srvCtx.unversioned))
+	for _, name := range srvCtx.unversioned {
+		if world := srvCtx.findMap(name); world != nil {
+			worlds = append(worlds, *world)
+		}
+	}
+
+	go func() {
+		built := 0
+		for i := range worlds {
+			log.Info().
+				Str("map", worlds[i].Name).
+				Msg("Manifest missing or outdated, hashing map files in the background (run the loader with --manifest-only to avoid it)")
+
+			if err := h.buildManifest(&worlds[i]); err != nil {
+				log.Warn().Err(err).Str("map", worlds[i].Name).Msg("Failed to hash map files, versioned URLs are disabled")
+				continue
+			}
+			built++
+		}
+
+		h.hashing.Store(false)
+		if built > 0 && !h.draining.Load() {
+			_ = h.Reload()
+		}
+	}()
+}
+
+// acquire returns the active context with its in-flight lock held.
+func (h *Holder) acquire() *ServerContext {
+	for {
+		srvCtx := h.current.Load()
+		srvCtx.inflight.RLock()
+		if !srvCtx.retired {
+			return srvCtx
+		}
+
+		// swapped out between Load and RLock, pick up the new context
+		srvCtx.inflight.RUnlock()
+	}
+}
+
+// retire waits for in-flight requests of a replaced context and releases its resources.
+func retire(srvCtx *ServerContext) {
+	srvCtx.inflight.Lock()
+	srvCtx.retired = true
+	srvCtx.inflight.Unlock()
+
+	if err := srvCtx.Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close replaced server context")
+	}
+}
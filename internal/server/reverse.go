@@ -0,0 +1,308 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/geo"
+)
+
+const (
+	reverseDefaultLimit = 5
+	reverseMaxLimit     = 50
+	// reverseAtDistance is the distance in metres below which a point is described as being at the place
+	reverseAtDistance = 100
+	// gridCellSize is the side in metres of a spatial index cell
+	gridCellSize = 1000
+)
+
+// compassPoints are the 8-wind compass directions starting from north clockwise.
+var compassPoints = [...]string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+// ReverseResult is a location near the queried point.
+type ReverseResult struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type,omitempty"`
+	Direction   string  `json:"direction"`
+	Description string  `json:"description"`
+	Lon         float64 `json:"lon"`
+	Lat         float64 `json:"lat"`
+	X           float64 `json:"x"`
+	Z           float64 `json:"z"`
+	Distance    float64 `json:"distance"`
+	Bearing     float64 `json:"bearing"`
+}
+
+// ReverseResponse is the body of the reverse geocoding API.
+type ReverseResponse struct {
+	Results []ReverseResult `json:"results"`
+	Lon     float64         `json:"lon"`
+	Lat     float64         `json:"lat"`
+	X       float64         `json:"x"`
+	Z       float64         `json:"z"`
+}
+
+// gridPoint is a location projected to game metres.
+type gridPoint struct {
+	x, z    float64
+	feature int
+}
+
+// spatialGrid is a uniform grid over the game world for nearest neighbour lookups.
+type spatialGrid struct {
+	cells map[[2]int][]gridPoint
+	size  int // cells per side
+}
+
+// newSpatialGrid indexes the point features of a map of the given size in metres.
+func newSpatialGrid(features []geo.GeoJSONFeature, mapSize float64) *spatialGrid {
+	g := &spatialGrid{
+		cells: make(map[[2]int][]gridPoint),
+		size:  int(math.Ceil(mapSize / gridCellSize)),
+	}
+
+	for i, f := range features {
+		if f.Geometry.Type != "Point" || len(f.Geometry.Coordinates) < 2 {
+			continue
+		}
+
+		x, z := geo.MetricZToGame(f.Geometry.Coordinates[0], f.Geometry.Coordinates[1], mapSize)
+		cell := g.cell(x, z)
+		g.cells[cell] = append(g.cells[cell], gridPoint{x: x, z: z, feature: i})
+	}
+
+	return g
+}
+
+// cell returns the grid cell holding the point,
+// points outside the map fall into the nearest edge cell.
+func (g *spatialGrid) cell(x, z float64) [2]int {
+	last := float64(g.size)
+	cx := math.Max(0, math.Min(last, math.Floor(x/gridCellSize)))
+	cz := math.Max(0, math.Min(last, math.Floor(z/gridCellSize)))
+
+	return [2]int{int(cx), int(cz)}
+}
+
+// within returns the points inside the box from (minX, minZ) to (maxX, maxZ).
+func (g *spatialGrid) within(minX, minZ, maxX, maxZ float64) []gridPoint {
+	var points []gridPoint
+
+	lo, hi := g.cell(minX, minZ), g.cell(maxX, maxZ)
+	for cx := lo[0]; cx <= hi[0]; cx++ {
+		for cz := lo[1]; cz <= hi[1]; cz++ {
+			for _, p := range g.cells[[2]int{cx, cz}] {
+				if p.x >= minX && p.z >= minZ && p.x <= maxX && p.z <= maxZ {
+					points = append(points, p)
+				}
+			}
+		}
+	}
+
+	return points
+}
+
+// nearest returns up to k points closest to (x, z) that pass the filter, nearest first.
+// It scans rings of cells around the point until no closer point can exist,
+// (x, z) must lie within the map.
+func (g *spatialGrid) nearest(x, z float64, k int, maxDist float64, keep func(int) bool) []gridPoint {
+	type found struct {
+		p    gridPoint
+		dist float64
+	}
+
+	var best []found
+	center := g.cell(x, z)
+
+	for ring := 0; ring <= g.size; ring++ {
+		// the nearest possible point in this ring is (ring-1) cells away
+		minRingDist := float64(ring-1) * gridCellSize
+		if ring > 0 && len(best) == k && minRingDist > best[k-1].dist {
+			break
+		}
+		if maxDist > 0 && ring > 0 && minRingDist > maxDist {
+			break
+		}
+
+		for cx := center[0] - ring; cx <= center[0]+ring; cx++ {
+			for cz := center[1] - ring; cz <= center[1]+ring; cz++ {
+				// only the border of the ring, inner cells were visited before
+				if ring > 0 && cx != center[0]-ring && cx != center[0]+ring && cz != center[1]-ring && cz != center[1]+ring {
+					continue
+				}
+
+				for _, p := range g.cells[[2]int{cx, cz}] {
+					d := math.Hypot(p.x-x, p.z-z)
+					if maxDist > 0 && d > maxDist {
+						continue
+					}
+					if len(best) == k && d >= best[k-1].dist {
+						continue
+					}
+					if keep != nil && !keep(p.feature) {
+						continue
+					}
+
+					i := sort.Search(len(best), func(i int) bool { return best[i].dist > d })
+					best = append(best, found{})
+					copy(best[i+1:], best[i:])
+					best[i] = found{p: p, dist: d}
+					if len(best) > k {
+						best = best[:k]
+					}
+				}
+			}
+		}
+	}
+
+	points := make([]gridPoint, len(best))
+	for i, f := range best {
+		points[i] = f.p
+	}
+
+	return points
+}
+
+// handleReverse returns the locations nearest to a point.
+//
+// Query parameters:
+//   - x, z: game coordinates in metres, or
+//   - lon, lat: WGS84 coordinates
+//   - type: comma separated list of location types
+//   - radius: maximum distance in metres
+//   - limit: maximum number of results (default 5, max 50)
+func (s *ServerContext) handleReverse(w http.ResponseWriter, r *http.Request, mapName string) {
+	locs := s.locations[mapName]
+	if locs == nil {
+		writeError(w, http.StatusNotFound, "map has no locations")
+		return
+	}
+	if locs.grid == nil {
+		writeError(w, http.StatusBadRequest, "map size is unknown, reverse geocoding is not supported")
+		return
+	}
+
+	q := r.URL.Query()
+	size := float64(s.findMap(mapName).Size)
+
+	var resp ReverseResponse
+	switch {
+	case q.Has("x") || q.Has("z"):
+		x, errX := strconv.ParseFloat(q.Get("x"), 64)
+		z, errZ := strconv.ParseFloat(q.Get("z"), 64)
+		if errX != nil || errZ != nil || !finite(x, z) {
+			writeError(w, http.StatusBadRequest, "invalid x or z")
+			return
+		}
+		resp.X, resp.Z = x, z
+		resp.Lon, resp.Lat = geo.GameToMetricZ(x, z, size)
+
+	case q.Has("lon") || q.Has("lat"):
+		lon, errLon := strconv.ParseFloat(q.Get("lon"), 64)
+		lat, errLat := strconv.ParseFloat(q.Get("lat"), 64)
+		if errLon != nil || errLat != nil || !finite(lon, lat) || math.Abs(lat) > geo.MaxLat || math.Abs(lon) > 180 {
+			writeError(w, http.StatusBadRequest, "invalid lon or lat")
+			return
+		}
+		resp.Lon, resp.Lat = lon, lat
+		resp.X, resp.Z = geo.MetricZToGame(lon, lat, size)
+
+	default:
+		writeError(w, http.StatusBadRequest, "x and z or lon and lat are required")
+		return
+	}
+
+	if resp.X < 0 || resp.Z < 0 || resp.X > size || resp.Z > size {
+		writeError(w, http.StatusBadRequest, "point is outside the map")
+		return
+	}
+
+	limit := reverseDefaultLimit
+	if raw := q.Get("limit"); raw != "" {
+		l, err := strconv.Atoi(raw)
+		if err != nil || l <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(l, reverseMaxLimit)
+	}
+
+	radius := 0.0
+	if raw := q.Get("radius"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !finite(v) || v <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid radius")
+			return
+		}
+		radius = v
+	}
+
+	var keep func(int) bool
+	if raw := q.Get("type"); raw != "" {
+		types := make(map[string]bool)
+		for _, t := range strings.Split(raw, ",") {
+			if t = strings.TrimSpace(strings.ToLower(t)); t != "" {
+				types[t] = true
+			}
+		}
+		keep = func(i int) bool {
+			typ, _ := locs.Features[i].Properties["type"].(string)
+			return types[typ]
+		}
+	}
+
+	resp.Results = make([]ReverseResult, 0, limit)
+	for _, p := range locs.grid.nearest(resp.X, resp.Z, limit, radius, keep) {
+		f := locs.Features[p.feature]
+		name, _ := f.Properties["name"].(string)
+		typ, _ := f.Properties["type"].(string)
+
+		dist := math.Hypot(resp.X-p.x, resp.Z-p.z)
+		deg := bearing(p.x, p.z, resp.X, resp.Z)
+		direction := compassPoints[int(math.Round(deg/45))%len(compassPoints)]
+
+		resp.Results = append(resp.Results, ReverseResult{
+			Name:        name,
+			Type:        typ,
+			Lon:         f.Geometry.Coordinates[0],
+			Lat:         f.Geometry.Coordinates[1],
+			X:           p.x,
+			Z:           p.z,
+			Distance:    math.Round(dist*10) / 10,
+			Bearing:     math.Round(deg*10) / 10,
+			Direction:   direction,
+			Description: describePlace(name, dist, direction),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// bearing returns the compass bearing in degrees from (x1, z1) to (x2, z2).
+// The game x axis points east and z points north.
+func bearing(x1, z1, x2, z2 float64) float64 {
+	deg := math.Atan2(x2-x1, z2-z1) * (180.0 / math.Pi)
+	if deg < 0 {
+		deg += 360
+	}
+
+	return deg
+}
+
+// describePlace renders a human readable position such as "2.1 km NE of Stary Sobor".
+func describePlace(name string, dist float64, direction string) string {
+	switch {
+	case dist < reverseAtDistance:
+		return name
+	case dist < 1000:
+		return fmt.Sprintf("%.0f m %s of %s", math.Round(dist/10)*10, direction, name)
+	default:
+		return fmt.Sprintf("%.1f km %s of %s", dist/1000, direction, name)
+	}
+}
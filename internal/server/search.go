@@ -0,0 +1,293 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/woozymasta/dzmap/internal/geo"
+)
+
+const (
+	searchDefaultLimit = 10
+	searchMaxLimit     = 100
+)
+
+// Scores of the match kinds, fuzzy matches lose searchEditPenalty per edit.
+const (
+	searchScoreExact       = 100
+	searchScorePrefix      = 90
+	searchScoreWordPrefix  = 80
+	searchScoreSubstring   = 70
+	searchScoreFuzzy       = 60
+	searchEditPenalty      = 10
+	searchMaxNameLengthGap = 2
+)
+
+// SearchResult is a single location matched by the search API.
+type SearchResult struct {
+	X     *float64 `json:"x,omitempty"`
+	Z     *float64 `json:"z,omitempty"`
+	Map   string   `json:"map"`
+	Name  string   `json:"name"`
+	Type  string   `json:"type,omitempty"`
+	Lon   float64  `json:"lon"`
+	Lat   float64  `json:"lat"`
+	Score int      `json:"score"`
+}
+
+// searchEntry is a location name prepared for matching.
+type searchEntry struct {
+	norm    string
+	words   []string
+	feature int
+}
+
+// diacritics maps accented Latin letters to their ASCII base.
+var diacritics = map[rune]string{
+	'á': "a", 'à': "a", 'â': "a", 'ä': "a", 'ã': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'č': "c", 'ć': "c", 'ç': "c", 'ĉ': "c",
+	'ď': "d", 'đ': "d",
+	'é': "e", 'è': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'í': "i", 'ì': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i",
+	'ł': "l", 'ľ': "l", 'ĺ': "l",
+	'ň': "n", 'ń': "n", 'ñ': "n",
+	'ó': "o", 'ò': "o", 'ô': "o", 'ö': "o", 'õ': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'ř': "r", 'ŕ': "r",
+	'š': "s", 'ś': "s", 'ş': "s", 'ș': "s",
+	'ť': "t", 'ţ': "t", 'ț': "t",
+	'ú': "u", 'ù': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ž': "z", 'ź': "z", 'ż': "z",
+	'ß': "ss", 'æ': "ae", 'œ': "oe",
+}
+
+// normalizeName lowercases, strips diacritics and collapses punctuation into single spaces.
+func normalizeName(s string) string {
+	var b strings.Builder
+	space := true
+
+	for _, r := range strings.ToLower(s) {
+		if repl, ok := diacritics[r]; ok {
+			b.WriteString(repl)
+			space = false
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+			continue
+		}
+		if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// buildSearchIndex prepares normalized names of the features.
+func buildSearchIndex(features []geo.GeoJSONFeature) []searchEntry {
+	index := make([]searchEntry, 0, len(features))
+	for i, f := range features {
+		name, _ := f.Properties["name"].(string)
+		norm := normalizeName(name)
+		if norm == "" || len(f.Geometry.Coordinates) < 2 {
+			continue
+		}
+		index = append(index, searchEntry{feature: i, norm: norm, words: strings.Fields(norm)})
+	}
+
+	return index
+}
+
+// HandleSearch searches location names across all maps.
+//
+// Query parameters:
+//   - q: search text (required)
+//   - map: comma separated map names or aliases to restrict the search to
+//   - type: comma separated location types
+//   - limit: maximum number of results (default 10, max 100)
+func (s *ServerContext) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := normalizeName(q.Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "missing query")
+		return
+	}
+
+	limit := searchDefaultLimit
+	if raw := q.Get("limit"); raw != "" {
+		l, err := strconv.Atoi(raw)
+		if err != nil || l <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(l, searchMaxLimit)
+	}
+
+	var maps []string
+	if raw := q.Get("map"); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			realName, ok := s.resolveMap(w, r, strings.TrimSpace(name))
+			if !ok {
+				writeError(w, http.StatusNotFound, "unknown map "+strconv.Quote(name))
+				return
+			}
+			maps = append(maps, realName)
+		}
+	} else {
+		for _, m := range s.visibleMaps(r) {
+			markPrivate(w, &m)
+			maps = append(maps, m.Name)
+		}
+	}
+
+	var types map[string]bool
+	if raw := q.Get("type"); raw != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(raw, ",") {
+			types[strings.TrimSpace(strings.ToLower(t))] = true
+		}
+	}
+
+	results := make([]SearchResult, 0)
+	for _, mapName := range maps {
+		locs := s.locations[mapName]
+		if locs == nil {
+			continue
+		}
+
+		size := 0.0
+		if world := s.findMap(mapName); world != nil {
+			size = float64(world.Size)
+		}
+
+		for _, e := range locs.index {
+			score := matchScore(query, e)
+			if score <= 0 {
+				continue
+			}
+
+			f := locs.Features[e.feature]
+			typ, _ := f.Properties["type"].(string)
+			if types != nil && !types[typ] {
+				continue
+			}
+
+			name, _ := f.Properties["name"].(string)
+			res := SearchResult{
+				Map:   mapName,
+				Name:  name,
+				Type:  typ,
+				Lon:   f.Geometry.Coordinates[0],
+				Lat:   f.Geometry.Coordinates[1],
+				Score: score,
+			}
+			if size > 0 {
+				x, z := geo.MetricZToGame(res.Lon, res.Lat, size)
+				res.X, res.Z = &x, &z
+			}
+
+			results = append(results, res)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if zi, zj := minZoomForType(results[i].Type), minZoomForType(results[j].Type); zi != zj {
+			return zi < zj
+		}
+		return results[i].Name < results[j].Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// matchScore rates how well the normalized query matches a name, 0 means no match.
+func matchScore(query string, e searchEntry) int {
+	switch {
+	case e.norm == query:
+		return searchScoreExact
+	case strings.HasPrefix(e.norm, query):
+		return searchScorePrefix
+	}
+
+	for _, word := range e.words {
+		if strings.HasPrefix(word, query) {
+			return searchScoreWordPrefix
+		}
+	}
+
+	if strings.Contains(e.norm, query) {
+		return searchScoreSubstring
+	}
+
+	// typo tolerance grows with the query length
+	maxEdits := 1
+	if n := len([]rune(query)); n < 4 {
+		return 0
+	} else if n >= 8 {
+		maxEdits = 2
+	}
+
+	best := maxEdits + 1
+	candidates := append([]string{e.norm}, e.words...)
+	for _, c := range candidates {
+		// compare against the prefix of the candidate to tolerate incomplete input
+		cr := []rune(c)
+		qr := []rune(query)
+		if len(cr) > len(qr)+searchMaxNameLengthGap {
+			cr = cr[:len(qr)]
+		}
+		if d := editDistance(qr, cr); d < best {
+			best = d
+		}
+	}
+
+	if best > maxEdits {
+		return 0
+	}
+
+	return searchScoreFuzzy - best*searchEditPenalty
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// counting insertions, deletions, substitutions and transpositions of adjacent runes.
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	return prev[len(b)]
+}
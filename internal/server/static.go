@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/geo"
+	"github.com/woozymasta/dzmap/internal/render"
+
+	"github.com/rs/zerolog/log"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// tileSize is the pixel size of served map tiles
+	tileSize = 256
+	// staticMaxSize limits the width and height of rendered images
+	staticMaxSize = 2048
+	// staticMaxPoints limits the number of overlay points of a single image
+	staticMaxPoints = 2000
+	// staticPadding keeps fitted overlays away from the image edges
+	staticPadding = 32
+	// staticPointMargin is how far overlay points may lie outside the map, as a fraction of its size
+	staticPointMargin = 0.1
+)
+
+var (
+	staticDefaultSize   = image.Point{X: 800, Y: 600}
+	staticMarkerColor   = color.RGBA{0xe0, 0x1b, 0x24, 0xff}
+	staticPathColor     = color.RGBA{0x1c, 0x71, 0xd8, 0xff}
+	staticLabelColor    = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	staticDefaultWeight = 3.0
+)
+
+// staticOverlay is a marker group, path or label of a static map request.
+type staticOverlay struct {
+	points []geoPoint
+	text   []string // label text, per marker or for the label point
+	weight float64
+	color  color.RGBA
+}
+
+// geoPoint is a position in game metres.
+type geoPoint struct{ x, z float64 }
+
+// staticRequest is a parsed static map request.
+type staticRequest struct {
+	center  *geoPoint
+	layer   string
+	format  string
+	markers []staticOverlay
+	paths   []staticOverlay
+	labels  []staticOverlay
+	size    image.Point
+	zoom    int
+}
+
+// HandleStaticMap renders a map image at /api/static/{name}.
+//
+// Query parameters:
+//   - center: x,z of the image centre, defaults to the overlays or the map centre
+//   - crs: "game" (x/z metres, default) or "wgs84" (lon/lat) for all coordinates
+//   - zoom: tile zoom level, defaults to fitting the overlays or the whole map
+//   - size: WIDTHxHEIGHT in pixels (default 800x600, max 2048x2048)
+//   - layer: layer name, defaults to the first base layer of the map
+//   - format: png (default), jpg or webp
+//   - markers: "color:red|label:Text|x,z|x,z", repeatable
+//   - path: "color:blue|weight:3|x,z|x,z|...", repeatable
+//   - label: "color:white|x,z,Text", repeatable
+func (s *ServerContext) HandleStaticMap(w http.ResponseWriter, r *http.Request) {
+	// Path: /api/static/{mapName}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	realMapName, ok := s.resolveMap(w, r, parts[2])
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown map")
+		return
+	}
+	world := s.findMap(realMapName)
+	if world == nil || world.Size <= 0 {
+		writeError(w, http.StatusBadRequest, "map size is unknown, static maps are not supported")
+		return
+	}
+
+	req, err := parseStaticRequest(r, float64(world.Size), world.ZoomLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.layer == "" {
+		req.layer = world.DefaultLayer()
+	}
+	if _, ok := s.tiles[realMapName][req.layer]; !ok {
+		writeError(w, http.StatusNotFound, "unknown layer")
+		return
+	}
+
+	img := s.renderStaticMap(realMapName, float64(world.Size), req)
+
+	var buf bytes.Buffer
+	if err := render.Encode(&buf, img, req.format); err != nil {
+		log.Error().Err(err).Str("map", realMapName).Msg("Failed to encode static map")
+		writeError(w, http.StatusInternalServerError, "failed to encode image")
+		return
+	}
+
+	w.Header().Set("Content-Type", render.ContentType(req.format))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	setCacheControl(w, "public, max-age=300")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// renderStaticMap stitches the tiles of the viewport and draws the overlays.
+func (s *ServerContext) renderStaticMap(mapName string, mapSize float64, req *staticRequest) *image.RGBA {
+	canvas := image.NewRGBA(image.Rectangle{Max: req.size})
+
+	// world pixel of the image top-left corner
+	cx, cy := worldPixel(*req.center, mapSize, req.zoom)
+	ox := int(math.Round(cx)) - req.size.X/2
+	oy := int(math.Round(cy)) - req.size.Y/2
+
+	n := 1 << req.zoom
+	for ty := floorDiv(oy, tileSize); ty <= floorDiv(oy+req.size.Y-1, tileSize); ty++ {
+		for tx := floorDiv(ox, tileSize); tx <= floorDiv(ox+req.size.X-1, tileSize); tx++ {
+			if tx < 0 || ty < 0 || tx >= n || ty >= n {
+				continue
+			}
+
+			t, _, _, ok := s.resolveTile(mapName, req.layer, req.zoom, tx, ty)
+			if !ok {
+				continue
+			}
+
+			src, ok := s.decodeTile(t, mapName, req.layer)
+			if !ok {
+				continue
+			}
+
+			dst := image.Rect(0, 0, tileSize, tileSize).Add(image.Pt(tx*tileSize-ox, ty*tileSize-oy))
+			if src.Bounds().Dx() == tileSize && src.Bounds().Dy() == tileSize {
+				draw.Draw(canvas, dst, src, src.Bounds().Min, draw.Src)
+			} else {
+				xdraw.BiLinear.Scale(canvas, dst, src, src.Bounds(), draw.Src, nil)
+			}
+		}
+	}
+
+	toPixel := func(p geoPoint) image.Point {
+		px, py := worldPixel(p, mapSize, req.zoom)
+		return image.Pt(int(math.Round(px))-ox, int(math.Round(py))-oy)
+	}
+
+	// one mask is shared by all paths, Line leaves it clear
+	var mask *image.Alpha
+	if len(req.paths) > 0 {
+		mask = image.NewAlpha(canvas.Bounds())
+	}
+	for _, path := range req.paths {
+		points := make([]image.Point, len(path.points))
+		for i, p := range path.points {
+			points[i] = toPixel(p)
+		}
+		render.Line(canvas, mask, points, path.weight, path.color)
+	}
+
+	for _, group := range req.markers {
+		for i, p := range group.points {
+			pt := toPixel(p)
+			render.Marker(canvas, pt, group.color)
+			if i < len(group.text) && group.text[i] != "" {
+				render.Label(canvas, pt.Add(image.Pt(0, -18)), group.text[i], staticLabelColor)
+			}
+		}
+	}
+
+	for _, label := range req.labels {
+		render.Label(canvas, toPixel(label.points[0]), label.text[0], label.color)
+	}
+
+	return canvas
+}
+
+// parseStaticRequest parses and validates the query of a static map request.
+func parseStaticRequest(r *http.Request, mapSize float64, zoomLimit int) (*staticRequest, error) {
+	q := r.URL.Query()
+	req := &staticRequest{size: staticDefaultSize, zoom: -1}
+
+	var toGame func(a, b float64) geoPoint
+	switch crs := strings.ToLower(q.Get("crs")); crs {
+	case "", "game":
+		toGame = func(x, z float64) geoPoint { return geoPoint{x, z} }
+	case "wgs84", "epsg:4326":
+		toGame = func(lon, lat float64) geoPoint {
+			x, z := geo.MetricZToGame(lon, math.Max(-geo.MaxLat, math.Min(geo.MaxLat, lat)), mapSize)
+			return geoPoint{x, z}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported crs %q", crs)
+	}
+
+	parsePoint := func(raw string) (geoPoint, error) {
+		a, b, ok := strings.Cut(raw, ",")
+		va, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		vb, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if !ok || errA != nil || errB != nil || !finite(va, vb) {
+			return geoPoint{}, fmt.Errorf("invalid point %q", raw)
+		}
+
+		p := toGame(va, vb)
+		margin := mapSize * staticPointMargin
+		if p.x < -margin || p.z < -margin || p.x > mapSize+margin || p.z > mapSize+margin {
+			return geoPoint{}, fmt.Errorf("point %q is outside the map", raw)
+		}
+		return p, nil
+	}
+
+	if raw := q.Get("center"); raw != "" {
+		p, err := parsePoint(raw)
+		if err != nil {
+			return nil, err
+		}
+		req.center = &p
+	}
+
+	if raw := q.Get("zoom"); raw != "" {
+		z, err := strconv.Atoi(raw)
+		if err != nil || z < 0 || z > zoomLimit {
+			return nil, fmt.Errorf("zoom must be between 0 and %d", zoomLimit)
+		}
+		req.zoom = z
+	}
+
+	if raw := q.Get("size"); raw != "" {
+		ws, hs, _ := strings.Cut(strings.ToLower(raw), "x")
+		width, errW := strconv.Atoi(ws)
+		height, errH := strconv.Atoi(hs)
+		if errW != nil || errH != nil || width <= 0 || height <= 0 || width > staticMaxSize || height > staticMaxSize {
+			return nil, fmt.Errorf("size must be WIDTHxHEIGHT up to %dx%d", staticMaxSize, staticMaxSize)
+		}
+		req.size = image.Pt(width, height)
+	}
+
+	req.layer = q.Get("layer")
+
+	format, err := render.ParseFormat(q.Get("format"))
+	if err != nil {
+		return nil, err
+	}
+	req.format = format
+
+	// Overlays
+	total := 0
+	for _, raw := range q["markers"] {
+		o, err := parseOverlay(raw, staticMarkerColor, parsePoint, false)
+		if err != nil {
+			return nil, fmt.Errorf("markers: %w", err)
+		}
+		total += len(o.points)
+		req.markers = append(req.markers, o)
+	}
+	for _, raw := range q["path"] {
+		o, err := parseOverlay(raw, staticPathColor, parsePoint, false)
+		if err != nil {
+			return nil, fmt.Errorf("path: %w", err)
+		}
+		total += len(o.points)
+		req.paths = append(req.paths, o)
+	}
+	for _, raw := range q["label"] {
+		o, err := parseOverlay(raw, staticLabelColor, parsePoint, true)
+		if err != nil {
+			return nil, fmt.Errorf("label: %w", err)
+		}
+		total += len(o.points)
+		req.labels = append(req.labels, o)
+	}
+	if total > staticMaxPoints {
+		return nil, fmt.Errorf("too many overlay points, the limit is %d", staticMaxPoints)
+	}
+
+	req.fitView(mapSize, zoomLimit)
+
+	return req, nil
+}
+
+// parseOverlay parses "key:value|...|x,z|x,z" overlay definitions.
+// For labels the single point is followed by the text: "x,z,Text".
+func parseOverlay(raw string, defColor color.RGBA, parsePoint func(string) (geoPoint, error), label bool) (staticOverlay, error) {
+	o := staticOverlay{color: defColor, weight: staticDefaultWeight}
+	var markerText string
+
+	for _, token := range strings.Split(raw, "|") {
+		key, value, isStyle := strings.Cut(token, ":")
+		if isStyle {
+			switch strings.ToLower(key) {
+			case "color":
+				c, err := render.ParseColor(value)
+				if err != nil {
+					return o, err
+				}
+				o.color = c
+			case "weight":
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil || !finite(v) || v <= 0 || v > 32 {
+					return o, fmt.Errorf("invalid weight %q", value)
+				}
+				o.weight = v
+			case "label":
+				markerText = value
+			default:
+				return o, fmt.Errorf("unknown style %q", key)
+			}
+			continue
+		}
+
+		if label {
+			x, rest, _ := strings.Cut(token, ",")
+			z, text, _ := strings.Cut(rest, ",")
+			p, err := parsePoint(x + "," + z)
+			if err != nil {
+				return o, err
+			}
+			if text == "" {
+				return o, fmt.Errorf("label text is empty")
+			}
+			o.points = append(o.points, p)
+			o.text = append(o.text, text)
+			continue
+		}
+
+		p, err := parsePoint(token)
+		if err != nil {
+			return o, err
+		}
+		o.points = append(o.points, p)
+		o.text = append(o.text, markerText)
+	}
+
+	if len(o.points) == 0 {
+		return o, fmt.Errorf("no points given")
+	}
+	if label && len(o.points) != 1 {
+		return o, fmt.Errorf("a label takes a single point")
+	}
+
+	return o, nil
+}
+
+// fitView fills in the centre and zoom not given in the request.
+// Without overlays the whole map is shown, otherwise the overlays are fitted into the image.
+func (req *staticRequest) fitView(mapSize float64, zoomLimit int) {
+	var points []geoPoint
+	for _, groups := range [][]staticOverlay{req.markers, req.paths, req.labels} {
+		for _, o := range groups {
+			points = append(points, o.points...)
+		}
+	}
+
+	minP := geoPoint{0, 0}
+	maxP := geoPoint{mapSize, mapSize}
+	if len(points) > 0 {
+		minP, maxP = points[0], points[0]
+		for _, p := range points[1:] {
+			minP = geoPoint{math.Min(minP.x, p.x), math.Min(minP.z, p.z)}
+			maxP = geoPoint{math.Max(maxP.x, p.x), math.Max(maxP.z, p.z)}
+		}
+	}
+
+	if req.center == nil {
+		req.center = &geoPoint{(minP.x + maxP.x) / 2, (minP.z + maxP.z) / 2}
+	}
+	if req.zoom >= 0 {
+		return
+	}
+
+	// largest zoom at which the extent around the centre fits into the image
+	req.zoom = 0
+	for z := zoomLimit; z > 0; z-- {
+		scale := float64(uint64(tileSize)<<z) / mapSize // pixels per metre
+		halfW := math.Max(req.center.x-minP.x, maxP.x-req.center.x) * scale
+		halfH := math.Max(req.center.z-minP.z, maxP.z-req.center.z) * scale
+		if 2*halfW <= float64(req.size.X-2*staticPadding) && 2*halfH <= float64(req.size.Y-2*staticPadding) {
+			req.zoom = z
+			break
+		}
+	}
+}
+
+// worldPixel returns the pixel position of a game point on the whole tile grid of zoom z.
+func worldPixel(p geoPoint, mapSize float64, z int) (px, py float64) {
+	tx, ty := geo.GameToTile(p.x, p.z, mapSize, z)
+	return tx * tileSize, ty * tileSize
+}
+
+// floorDiv divides rounding towards negative infinity.
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/woozymasta/dzmap/internal/config"
+)
+
+const testMapSize = 15360
+
+// zigzagPath returns a path overlay crossing the map n times between its corners.
+func zigzagPath(n int) string {
+	parts := []string{"weight:32"}
+	for i := range n {
+		if i%2 == 0 {
+			parts = append(parts, "0,0")
+		} else {
+			parts = append(parts, "15360,15360")
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+func TestParseStaticRequestPoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		values url.Values
+		err    string
+	}{
+		{"inside the margin", url.Values{"markers": {"-1000,16000"}}, ""},
+		{"outside the map", url.Values{"markers": {"-2000,100"}}, "outside the map"},
+		{"far outside the map", url.Values{"path": {"0,0|1e12,0"}}, "outside the map"},
+		{"wgs84 longitude outside the map", url.Values{"crs": {"wgs84"}, "markers": {"500,0"}}, "outside the map"},
+		{"too many points", url.Values{"path": {zigzagPath(staticMaxPoints + 1)}}, "too many"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/static/test?"+tt.values.Encode(), nil)
+			_, err := parseStaticRequest(r, testMapSize, 6)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("parseStaticRequest(): %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("parseStaticRequest() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRenderStaticMapPointLimit(t *testing.T) {
+	s := &ServerContext{Config: &config.Config{}}
+	values := url.Values{
+		"size": {"2048x2048"},
+		"zoom": {"6"},
+		"path": {zigzagPath(staticMaxPoints)},
+	}
+	r := httptest.NewRequest("GET", "/api/static/test?"+values.Encode(), nil)
+
+	req, err := parseStaticRequest(r, testMapSize, 6)
+	if err != nil {
+		t.Fatalf("parseStaticRequest(): %v", err)
+	}
+
+	img := s.renderStaticMap("test", testMapSize, req)
+	if got := img.Bounds().Size(); got.X != 2048 || got.Y != 2048 {
+		t.Fatalf("image size = %v, want 2048x2048", got)
+	}
+	// the diagonal path runs through the map centre in the middle of the image
+	if a := img.RGBAAt(1024, 1024).A; a == 0 {
+		t.Error("centre pixel is transparent, want the path drawn")
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"time"
+
+	"github.com/chai2010/webp"
+	"github.com/rs/zerolog/log"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// synthMaxOverzoom is how many levels above an existing ancestor a tile is upscaled from
+	synthMaxOverzoom = 8
+	// synthMaxBeyondNative is how many levels beyond the native zoom of a layer tiles are
+	// synthesized, as far as the web viewer zooms in
+	synthMaxBeyondNative = 3
+	// synthMaxUnderzoom is how many levels of descendants a tile is downsampled from
+	synthMaxUnderzoom = 2
+	// synthQuality is the WebP quality of synthesized tiles
+	synthQuality = 85
+	// variantSynth marks synthesized tiles in the tile cache
+	variantSynth = "synth"
+)
+
+// synthesizeTile builds a tile missing on both layers from the tiles around it.
+// Below the native zoom the four children are downsampled, otherwise the nearest
+// ancestor is cropped and upscaled. Results, including failures, are cached.
+func (s *ServerContext) synthesizeTile(mapName, layer string, z, x, y int) (tile, bool) {
+	nativeZoom := 0
+	if l := s.findLayer(mapName, layer); l != nil {
+		nativeZoom = l.ZoomLimit
+	}
+	// deeper tiles only blur the same pixels and cost a scale and an encode each
+	if z > nativeZoom+synthMaxBeyondNative || x >= 1<<z || y >= 1<<z {
+		return tile{}, false
+	}
+
+	return s.derivedTile(tileKey{mapName: mapName, layer: layer, variant: variantSynth, z: z, x: x, y: y}, func() (tile, bool) {
+		img, modTime, ok := image.Image(nil), time.Time{}, false
+		if z < nativeZoom {
+			img, modTime, ok = s.underzoom(mapName, layer, z, x, y, synthMaxUnderzoom)
+		}
+		if !ok {
+			img, modTime, ok = s.overzoom(mapName, layer, z, x, y)
+		}
+		if !ok {
+			return tile{}, false
+		}
+
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: synthQuality}); err != nil {
+			log.Error().Err(err).Str("map", mapName).Str("layer", layer).Msg("Failed to encode synthesized tile")
+			return tile{}, false
+		}
+
+		return tile{Data: buf.Bytes(), ModTime: modTime}, true
+	})
+}
+
+// overzoom crops the area of the tile from its nearest stored ancestor and upscales it.
+func (s *ServerContext) overzoom(mapName, layer string, z, x, y int) (image.Image, time.Time, bool) {
+	for d := 1; d <= synthMaxOverzoom && d <= z; d++ {
+		t, _, ok := s.lookupTile(mapName, layer, z-d, x>>d, y>>d)
+		if !ok {
+			continue
+		}
+
+		src, ok := s.decodeTile(t, mapName, layer)
+		if !ok {
+			return nil, time.Time{}, false
+		}
+
+		// part of the ancestor covered by the tile
+		b := src.Bounds()
+		mask := 1<<d - 1
+		crop := image.Rect(
+			b.Min.X+(x&mask)*b.Dx()>>d, b.Min.Y+(y&mask)*b.Dy()>>d,
+			b.Min.X+((x&mask)+1)*b.Dx()>>d, b.Min.Y+((y&mask)+1)*b.Dy()>>d,
+		)
+		if crop.Empty() {
+			return nil, time.Time{}, false
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+
+		return dst, t.ModTime, true
+	}
+
+	return nil, time.Time{}, false
+}
+
+// underzoom downsamples the four children of a tile into it, synthesizing missing
+// children from their own children down to depth levels.
+func (s *ServerContext) underzoom(mapName, layer string, z, x, y, depth int) (image.Image, time.Time, bool) {
+	dst := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	var modTime time.Time
+	found := false
+
+	for i := range 4 {
+		cx, cy := x*2+i%2, y*2+i/2
+
+		var child image.Image
+		t, _, ok := s.lookupTile(mapName, layer, z+1, cx, cy)
+		if ok {
+			child, ok = s.decodeTile(t, mapName, layer)
+		} else if depth > 1 {
+			child, t.ModTime, ok = s.underzoom(mapName, layer, z+1, cx, cy, depth-1)
+		}
+		if !ok {
+			continue
+		}
+
+		half := tileSize / 2
+		quadrant := image.Rect(0, 0, half, half).Add(image.Pt(i%2*half, i/2*half))
+		xdraw.BiLinear.Scale(dst, quadrant, child, child.Bounds(), draw.Src, nil)
+
+		if t.ModTime.After(modTime) {
+			modTime = t.ModTime
+		}
+		found = true
+	}
+
+	return dst, modTime, found
+}
+
+// decodeTile decodes a stored WebP tile, logging failures.
+func (s *ServerContext) decodeTile(t tile, mapName, layer string) (image.Image, bool) {
+	img, err := webp.Decode(bytes.NewReader(t.Data))
+	if err != nil {
+		log.Warn().Err(err).Str("map", mapName).Str("layer", layer).Msg("Failed to decode tile")
+		return nil, false
+	}
+
+	return img, true
+}
+
+// derivedTile returns a tile computed by build, caching the result in the derived tile cache.
+func (s *ServerContext) derivedTile(key tileKey, build func() (tile, bool)) (tile, bool) {
+	if e, ok := s.derived.Get(key); ok && e.valid() {
+		return e.tile, e.ok
+	}
+
+	t, ok := build()
+	if ok {
+		t.ETag = contentETag(t.Data)
+	}
+	s.derived.Add(key, newCachedTile(t, ok), int64(len(t.Data))+tileEntryOverhead)
+	if s.derived == s.cache {
+		tileCacheBytes.Set(float64(s.cache.Size()))
+	}
+
+	return t, ok
+}
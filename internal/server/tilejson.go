@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/config"
+	"github.com/woozymasta/dzmap/internal/geo"
+)
+
+// TileJSON describes a tile layer following the TileJSON 3.0.0 specification.
+type TileJSON struct {
+	TileJSON     string        `json:"tilejson"`
+	Name         string        `json:"name,omitempty"`
+	Description  string        `json:"description,omitempty"`
+	Attribution  string        `json:"attribution,omitempty"`
+	Scheme       string        `json:"scheme"`
+	Tiles        []string      `json:"tiles"`
+	Data         []string      `json:"data,omitempty"`
+	VectorLayers []VectorLayer `json:"vector_layers,omitempty"`
+	Bounds       [4]float64    `json:"bounds"`
+	Center       [3]float64    `json:"center"`
+	MinZoom      int           `json:"minzoom"`
+	MaxZoom      int           `json:"maxzoom"`
+	TileSize     int           `json:"tileSize,omitempty"`
+}
+
+// VectorLayer describes a layer of vector data and its attributes.
+type VectorLayer struct {
+	Fields      map[string]string `json:"fields"`
+	ID          string            `json:"id"`
+	Description string            `json:"description,omitempty"`
+}
+
+// locationsLayer describes the features of locations.geojson.
+var locationsLayer = VectorLayer{
+	ID:          "locations",
+	Description: "Named locations (cities, villages, points of interest)",
+	Fields: map[string]string{
+		"name": "String",
+		"type": "String",
+	},
+}
+
+// serveTileJSON writes the TileJSON document for a map layer.
+// The format query parameter selects png or jpg tile URLs instead of webp.
+// With hidpi the document points to the 512px @2x tiles, which reach the native
+// detail one zoom level earlier.
+func (s *ServerContext) serveTileJSON(w http.ResponseWriter, r *http.Request, world *config.Map, layer string, hidpi bool) {
+	base := s.baseURL(r) + "/maps/" + versionedPath(world)
+	creds := accessQuery(r, world)
+	l := world.Layer(layer)
+
+	ext := ".webp"
+	if format := r.URL.Query().Get("format"); format != "" {
+		ext = "." + strings.ToLower(format)
+		if _, ok := tileExtensions[ext]; !ok {
+			http.Error(w, "unsupported format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	doc := TileJSON{
+		TileJSON:    "3.0.0",
+		Name:        world.Name + " " + layer,
+		Description: "DayZ " + world.Name + " " + layer + " tiles",
+		Attribution: l.Attribution,
+		Scheme:      "xyz",
+		Tiles:       []string{base + "/" + layer + "/{z}/{x}/{y}" + ext + creds},
+		MinZoom:     0,
+		MaxZoom:     l.ZoomLimit,
+		Bounds:      [4]float64{-180, -geo.MaxLat, 180, geo.MaxLat},
+	}
+	if hidpi {
+		doc.Tiles = []string{base + "/" + layer + "/{z}/{x}/{y}" + hidpiSuffix + ext + creds}
+		doc.MaxZoom = max(l.ZoomLimit-1, 0)
+		doc.TileSize = tileSize * 2
+	}
+
+	if world.Size > 0 {
+		size := float64(world.Size)
+		centerLon, centerLat := geo.GameToMetricZ(size/2, size/2, size)
+
+		doc.Bounds = mapBounds(world)
+		doc.Center = [3]float64{centerLon, centerLat, 0}
+	}
+
+	if _, err := os.Stat(filepath.Join("maps", world.Name, "locations.geojson")); err == nil {
+		doc.Data = []string{base + "/locations.geojson" + creds}
+		doc.VectorLayers = []VectorLayer{locationsLayer}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, "public, no-cache")
+	_ = json.NewEncoder(w).Encode(doc)
+}
+
+// serveLocationsTileJSON writes the TileJSON document for the locations vector tiles.
+func (s *ServerContext) serveLocationsTileJSON(w http.ResponseWriter, r *http.Request, world *config.Map) {
+	base := s.baseURL(r) + "/maps/" + versionedPath(world)
+	creds := accessQuery(r, world)
+
+	doc := TileJSON{
+		TileJSON:     "3.0.0",
+		Name:         world.Name + " locations",
+		Description:  "DayZ " + world.Name + " named locations",
+		Attribution:  world.Attribution,
+		Scheme:       "xyz",
+		Tiles:        []string{base + "/locations/{z}/{x}/{y}.mvt" + creds},
+		Data:         []string{base + "/locations.geojson" + creds},
+		VectorLayers: []VectorLayer{locationsLayer},
+		MinZoom:      0,
+		MaxZoom:      mvtMaxZoom,
+		Bounds:       [4]float64{-180, -geo.MaxLat, 180, geo.MaxLat},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, "public, no-cache")
+	_ = json.NewEncoder(w).Encode(doc)
+}
+
+// findMap returns the validated map configuration by its canonical name.
+func (s *ServerContext) findMap(name string) *config.Map {
+	return s.Config.FindMap(name)
+}
+
+// findLayer returns the available layer of a map by name, or nil.
+func (s *ServerContext) findLayer(mapName, layer string) *config.Layer {
+	world := s.findMap(mapName)
+	if world == nil {
+		return nil
+	}
+
+	return world.Layer(layer)
+}
+
+// hasLayer reports whether the map has tiles for the given layer.
+func (s *ServerContext) hasLayer(mapName, layer string) bool {
+	_, ok := s.tiles[mapName][layer]
+	return ok
+}
+
+// mapBounds returns the WGS84 bounds of the map as min lon, min lat, max lon, max lat,
+// the whole Web Mercator world when the map size is unknown.
+func mapBounds(world *config.Map) [4]float64 {
+	if world.Size <= 0 {
+		return [4]float64{-180, -geo.MaxLat, 180, geo.MaxLat}
+	}
+
+	size := float64(world.Size)
+	minLon, minLat := geo.GameToMetricZ(0, 0, size)
+	maxLon, maxLat := geo.GameToMetricZ(size, size, size)
+
+	return [4]float64{minLon, minLat, maxLon, maxLat}
+}
+
+// baseURL reconstructs the public scheme, host and base path of the request,
+// honouring X-Forwarded-Proto and X-Forwarded-Host set by reverse proxies.
+func (s *ServerContext) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+
+	// forwarded headers end up in publicly cached documents, only proxies may set them
+	if fromTrustedProxy(r, s.trustedProxies) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+			host = fwd
+		}
+	}
+
+	return scheme + "://" + host + s.basePath
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/woozymasta/dzmap/internal/cache"
+	"github.com/woozymasta/dzmap/internal/pmtiles"
+)
+
+// tile is an encoded tile image with its modification time.
+type tile struct {
+	ModTime time.Time
+	// ETag is the content hash ETag, set once the tile is cached, see tileETag
+	ETag string
+	Data []byte
+}
+
+// tileSource reads encoded tiles of a single map layer.
+type tileSource interface {
+	// Tile returns the tile, or ok=false if the layer has no such tile.
+	Tile(z, x, y int) (t tile, ok bool, err error)
+	Close() error
+}
+
+// openLayer opens the tile source for maps/{mapName}/{layer}.
+// A PMTiles archive takes precedence over a zip or tar archive, which take
+// precedence over a tile directory; tiles missing in a zip or tar archive are
+// still read from the directory.
+// It returns fs.ErrNotExist if none is present.
+func openLayer(mapName, layer string) (tileSource, error) {
+	archive := filepath.Join("maps", mapName, layer+".pmtiles")
+	if info, err := os.Stat(archive); err == nil && !info.IsDir() {
+		r, err := pmtiles.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		return &pmtilesSource{reader: r, modTime: info.ModTime()}, nil
+	}
+
+	var dir tileSource
+	if path := filepath.Join("maps", mapName, layer); isDir(path) {
+		dir = dirSource(path)
+	}
+
+	for _, ext := range archiveExtensions {
+		path := filepath.Join("maps", mapName, layer+ext)
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		return openArchive(path, dir)
+	}
+
+	if dir != nil {
+		return dir, nil
+	}
+
+	return nil, fs.ErrNotExist
+}
+
+// isDir reports whether path is an existing directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// dirSource serves tiles from a {z}/{x}/{y}.webp directory tree.
+type dirSource string
+
+// Tile implements tileSource.
+func (d dirSource) Tile(z, x, y int) (tile, bool, error) {
+	path := filepath.Join(string(d), strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".webp")
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return tile{}, false, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return tile{}, false, nil
+		}
+		return tile{}, false, err
+	}
+
+	return tile{Data: data, ModTime: info.ModTime()}, true, nil
+}
+
+// Close implements tileSource.
+func (d dirSource) Close() error { return nil }
+
+// pmtilesSource serves tiles from a PMTiles archive.
+type pmtilesSource struct {
+	modTime time.Time
+	reader  *pmtiles.Reader
+}
+
+// Tile implements tileSource.
+func (p *pmtilesSource) Tile(z, x, y int) (tile, bool, error) {
+	if z < 0 || z > 255 || x < 0 || y < 0 {
+		return tile{}, false, nil
+	}
+
+	data, ok, err := p.reader.Tile(uint8(z), uint32(x), uint32(y))
+	if err != nil || !ok {
+		return tile{}, false, err
+	}
+
+	return tile{Data: data, ModTime: p.modTime}, true, nil
+}
+
+// Close implements tileSource.
+func (p *pmtilesSource) Close() error { return p.reader.Close() }
+
+// parseTileCoords parses the z, x and "{y}{ext}" path segments of a tile request.
+func parseTileCoords(zs, xs, ys, ext string) (z, x, y int, ok bool) {
+	ys, found := strings.CutSuffix(ys, ext)
+	if !found {
+		return 0, 0, 0, false
+	}
+
+	z, errZ := strconv.Atoi(zs)
+	x, errX := strconv.Atoi(xs)
+	y, errY := strconv.Atoi(ys)
+	if errZ != nil || errX != nil || errY != nil || z < 0 || x < 0 || y < 0 {
+		return 0, 0, 0, false
+	}
+
+	return z, x, y, true
+}
+
+// tileEntryOverhead approximates the memory used by a cache entry besides tile data,
+// so negative entries also count against the budget.
+const tileEntryOverhead = 128
+
+// missingTileTTL is how long a known missing tile is remembered, so tiles added
+// to the layer later are picked up without a reload.
+const missingTileTTL = time.Minute
+
+// tileKey identifies a tile in the tile cache.
+// Tiles computed by the server are told apart from stored ones by variant.
+type tileKey struct {
+	mapName string
+	layer   string
+	variant string
+	z, x, y int
+}
+
+// cachedTile is a tile cache entry; ok=false records a known missing tile
+// until expires.
+type cachedTile struct {
+	expires time.Time
+	tile    tile
+	ok      bool
+}
+
+// newCachedTile returns the cache entry of a tile lookup result.
+func newCachedTile(t tile, ok bool) cachedTile {
+	e := cachedTile{tile: t, ok: ok}
+	if !ok {
+		e.expires = time.Now().Add(missingTileTTL)
+	}
+
+	return e
+}
+
+// valid reports whether the entry may still be used.
+func (e cachedTile) valid() bool {
+	return e.ok || time.Now().Before(e.expires)
+}
+
+// tileCache is an LRU of tiles shared by all layers of a server context.
+type tileCache = cache.LRU[tileKey, cachedTile]
+
+// cachedSource serves tiles of a layer from the tile cache, reading through to src on a miss.
+type cachedSource struct {
+	src     tileSource
+	cache   *tileCache
+	mapName string
+	layer   string
+}
+
+// Tile implements tileSource.
+func (c *cachedSource) Tile(z, x, y int) (tile, bool, error) {
+	key := tileKey{mapName: c.mapName, layer: c.layer, z: z, x: x, y: y}
+	if e, ok := c.cache.Get(key); ok && e.valid() {
+		tileCacheRequests.Inc("hit")
+		return e.tile, e.ok, nil
+	}
+	tileCacheRequests.Inc("miss")
+
+	t, ok, err := c.src.Tile(z, x, y)
+	if err != nil {
+		// don't cache I/O errors, they may be transient
+		return t, ok, err
+	}
+
+	if ok {
+		t.ETag = contentETag(t.Data)
+	}
+	c.cache.Add(key, newCachedTile(t, ok), int64(len(t.Data))+tileEntryOverhead)
+	tileCacheBytes.Set(float64(c.cache.Size()))
+
+	return t, ok, nil
+}
+
+// Close implements tileSource.
+func (c *cachedSource) Close() error { return c.src.Close() }
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// certCheckInterval limits how often the certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// CertReloader serves a TLS certificate from files and reloads it when they change,
+// so renewed certificates are picked up without a restart.
+type CertReloader struct {
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+	certFile  string
+	keyFile   string
+	mu        sync.Mutex
+}
+
+// NewCertReloader loads the certificate and key pair from the PEM files.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile}
+
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cert, c.modTime, c.lastCheck = &cert, modTime, time.Now()
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, reloading the files when they
+// changed since the last load. On reload errors the previous certificate is kept.
+// It is meant for tls.Config.GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.lastCheck = time.Now()
+
+	modTime, err := c.filesModTime()
+	if err != nil || !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		// the pair may be mid-update, retry on the next check
+		log.Warn().Err(err).Str("cert", c.certFile).Msg("Failed to reload TLS certificate, keeping current one")
+		return c.cert, nil
+	}
+
+	c.cert, c.modTime = &cert, modTime
+	log.Info().Str("cert", c.certFile).Msg("TLS certificate reloaded")
+
+	return c.cert, nil
+}
+
+// filesModTime returns the latest modification time of the certificate and key files.
+func (c *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/woozymasta/dzmap/internal/render"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tileFormats are the formats tiles can be served in, the first one is stored on disk.
+var tileFormats = []string{render.FormatWebP, render.FormatPNG, render.FormatJPEG}
+
+// tileExtensions maps tile file extensions to formats.
+var tileExtensions = map[string]string{
+	".webp": render.FormatWebP,
+	".png":  render.FormatPNG,
+	".jpg":  render.FormatJPEG,
+	".jpeg": render.FormatJPEG,
+}
+
+// tileFormat resolves the format of a tile request.
+// An explicit .png or .jpg extension selects the format, for .webp and no extension
+// the Accept header is negotiated, keeping WebP for clients that accept it.
+// negotiated reports whether the response depends on the Accept header.
+func tileFormat(ext, accept string) (format string, negotiated, ok bool) {
+	if ext != "" && ext != ".webp" {
+		format, ok = tileExtensions[strings.ToLower(ext)]
+		return format, false, ok
+	}
+
+	return negotiateFormat(accept), true, true
+}
+
+// negotiateFormat picks the tile format preferred by an Accept header.
+// WebP wins ties and is used when nothing supported is acceptable.
+func negotiateFormat(accept string) string {
+	if accept == "" {
+		return render.FormatWebP
+	}
+
+	quality := make(map[string]float64, len(tileFormats))
+	wildcard := -1.0
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(raw, 64); err == nil {
+				q = v
+			}
+		}
+
+		switch mediaType {
+		case "*/*", "image/*":
+			wildcard = max(wildcard, q)
+		default:
+			for _, f := range tileFormats {
+				if mediaType == render.ContentType(f) {
+					quality[f] = max(quality[f], q)
+				}
+			}
+		}
+	}
+
+	best, bestQ := render.FormatWebP, 0.0
+	for _, f := range tileFormats {
+		q, ok := quality[f]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+
+	return best
+}
+
+// transcodeTile converts a stored WebP tile to format, caching the result.
+func (s *ServerContext) transcodeTile(key tileKey, t tile, format string) (tile, bool) {
+	if format == render.FormatWebP {
+		return t, true
+	}
+
+	// keep transcoded high-DPI tiles apart from regular ones
+	key.variant += format
+	return s.derivedTile(key, func() (tile, bool) {
+		img, ok := s.decodeTile(t, key.mapName, key.layer)
+		if !ok {
+			return tile{}, false
+		}
+
+		var buf bytes.Buffer
+		if err := render.Encode(&buf, img, format); err != nil {
+			log.Error().
+				Err(err).
+				Str("map", key.mapName).
+				Str("layer", key.layer).
+				Str("format", format).
+				Msg("Failed to transcode tile")
+			return tile{}, false
+		}
+
+		return tile{Data: buf.Bytes(), ModTime: t.ModTime}, true
+	})
+}
+
+// transparentTiles encodes the 1x1 transparent tile in every served format.
+// JPEG has no alpha channel, its tile is white.
+func transparentTiles(webp []byte) map[string][]byte {
+	tiles := map[string][]byte{render.FormatWebP: webp}
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+
+	for _, f := range tileFormats[1:] {
+		var buf bytes.Buffer
+		if err := render.Encode(&buf, img, f); err == nil {
+			tiles[f] = buf.Bytes()
+		}
+	}
+
+	return tiles
+}
@@ -0,0 +1,407 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/woozymasta/dzmap/internal/render"
+)
+
+const (
+	wmtsTileMatrixSet = "GoogleMapsCompatible"
+
+	// scale denominator of zoom level 0 for 256px tiles in EPSG:3857 at 0.28mm pixels
+	wmtsScaleDenominator0 = 559082264.0287178
+	// half of the EPSG:3857 world extent in metres
+	webMercatorOrigin = 20037508.3427892
+)
+
+type wmtsCapabilities struct {
+	XMLName            xml.Name               `xml:"Capabilities"`
+	Xmlns              string                 `xml:"xmlns,attr"`
+	XmlnsOws           string                 `xml:"xmlns:ows,attr"`
+	XmlnsXlink         string                 `xml:"xmlns:xlink,attr"`
+	Version            string                 `xml:"version,attr"`
+	ServiceIdent       wmtsServiceIdent       `xml:"ows:ServiceIdentification"`
+	OperationsMeta     wmtsOperationsMetadata `xml:"ows:OperationsMetadata"`
+	Contents           wmtsContents           `xml:"Contents"`
+	ServiceMetadataURL wmtsHref               `xml:"ServiceMetadataURL"`
+}
+
+type wmtsServiceIdent struct {
+	Title              string `xml:"ows:Title"`
+	ServiceType        string `xml:"ows:ServiceType"`
+	ServiceTypeVersion string `xml:"ows:ServiceTypeVersion"`
+}
+
+type wmtsOperationsMetadata struct {
+	Operations []wmtsOperation `xml:"ows:Operation"`
+}
+
+type wmtsOperation struct {
+	Name string  `xml:"name,attr"`
+	Get  wmtsGet `xml:"ows:DCP>ows:HTTP>ows:Get"`
+}
+
+type wmtsGet struct {
+	Href       string         `xml:"xlink:href,attr"`
+	Constraint wmtsConstraint `xml:"ows:Constraint"`
+}
+
+type wmtsConstraint struct {
+	Name   string `xml:"name,attr"`
+	Values string `xml:"ows:AllowedValues>ows:Value"`
+}
+
+type wmtsContents struct {
+	Layers        []wmtsLayer        `xml:"Layer"`
+	TileMatrixSet wmtsTileMatrixSetT `xml:"TileMatrixSet"`
+}
+
+type wmtsLayer struct {
+	Title       string             `xml:"ows:Title"`
+	Abstract    string             `xml:"ows:Abstract,omitempty"`
+	BoundingBox wmtsBBox           `xml:"ows:WGS84BoundingBox"`
+	Identifier  string             `xml:"ows:Identifier"`
+	Styles      []wmtsStyle        `xml:"Style"`
+	Formats     []string           `xml:"Format"`
+	Link        wmtsTileMatrixLink `xml:"TileMatrixSetLink"`
+	ResourceURL []wmtsResourceURL  `xml:"ResourceURL"`
+}
+
+type wmtsBBox struct {
+	LowerCorner string `xml:"ows:LowerCorner"`
+	UpperCorner string `xml:"ows:UpperCorner"`
+}
+
+type wmtsStyle struct {
+	IsDefault  bool   `xml:"isDefault,attr,omitempty"`
+	Title      string `xml:"ows:Title"`
+	Identifier string `xml:"ows:Identifier"`
+}
+
+type wmtsTileMatrixLink struct {
+	TileMatrixSet string             `xml:"TileMatrixSet"`
+	Limits        []wmtsMatrixLimits `xml:"TileMatrixSetLimits>TileMatrixLimits"`
+}
+
+type wmtsMatrixLimits struct {
+	TileMatrix string `xml:"TileMatrix"`
+	MinTileRow int    `xml:"MinTileRow"`
+	MaxTileRow int    `xml:"MaxTileRow"`
+	MinTileCol int    `xml:"MinTileCol"`
+	MaxTileCol int    `xml:"MaxTileCol"`
+}
+
+type wmtsResourceURL struct {
+	Format       string `xml:"format,attr"`
+	ResourceType string `xml:"resourceType,attr"`
+	Template     string `xml:"template,attr"`
+}
+
+type wmtsTileMatrixSetT struct {
+	Identifier   string           `xml:"ows:Identifier"`
+	SupportedCRS string           `xml:"ows:SupportedCRS"`
+	WellKnown    string           `xml:"WellKnownScaleSet"`
+	Matrices     []wmtsTileMatrix `xml:"TileMatrix"`
+}
+
+type wmtsTileMatrix struct {
+	Identifier       string  `xml:"ows:Identifier"`
+	ScaleDenominator float64 `xml:"ScaleDenominator"`
+	TopLeftCorner    string  `xml:"TopLeftCorner"`
+	TileWidth        int     `xml:"TileWidth"`
+	TileHeight       int     `xml:"TileHeight"`
+	MatrixWidth      int     `xml:"MatrixWidth"`
+	MatrixHeight     int     `xml:"MatrixHeight"`
+}
+
+type wmtsHref struct {
+	Href string `xml:"xlink:href,attr"`
+}
+
+type wmtsException struct {
+	XMLName   xml.Name `xml:"ows:ExceptionReport"`
+	XmlnsOws  string   `xml:"xmlns:ows,attr"`
+	Version   string   `xml:"version,attr"`
+	Exception struct {
+		Code    string `xml:"exceptionCode,attr"`
+		Locator string `xml:"locator,attr,omitempty"`
+		Text    string `xml:"ows:ExceptionText"`
+	} `xml:"ows:Exception"`
+}
+
+// HandleWMTS serves an OGC WMTS 1.0.0 service.
+// It supports KVP requests on /wmts and RESTful requests under /wmts/1.0.0/.
+func (s *ServerContext) HandleWMTS(w http.ResponseWriter, r *http.Request) {
+	reqPath := strings.Trim(r.URL.Path, "/")
+
+	if reqPath == "wmts" {
+		s.handleWMTSKVP(w, r)
+		return
+	}
+
+	// parts: wmts, 1.0.0, ...
+	parts := strings.Split(reqPath, "/")
+	if len(parts) < 3 || parts[1] != "1.0.0" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 3 && parts[2] == "WMTSCapabilities.xml" {
+		s.serveWMTSCapabilities(w, r)
+		return
+	}
+
+	// parts: wmts, 1.0.0, layer, style, tileMatrixSet, z, row, col.{webp,png,jpg}
+	if len(parts) == 8 {
+		ext := path.Ext(parts[7])
+		if format, ok := tileExtensions[ext]; ok {
+			col := strings.TrimSuffix(parts[7], ext)
+			s.serveWMTSTile(w, r, parts[2], parts[3], parts[4], parts[5], parts[6], col, format)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// handleWMTSKVP dispatches key-value-pair encoded WMTS requests.
+func (s *ServerContext) handleWMTSKVP(w http.ResponseWriter, r *http.Request) {
+	// KVP parameter names are case-insensitive
+	params := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			params[strings.ToUpper(k)] = v[0]
+		}
+	}
+
+	if service := params["SERVICE"]; service != "" && !strings.EqualFold(service, "WMTS") {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "service", "Only the WMTS service is supported")
+		return
+	}
+
+	switch strings.ToLower(params["REQUEST"]) {
+	case "getcapabilities":
+		s.serveWMTSCapabilities(w, r)
+
+	case "gettile":
+		format := render.FormatWebP
+		if raw := params["FORMAT"]; raw != "" {
+			format = strings.TrimPrefix(strings.ToLower(raw), "image/")
+			if !slices.Contains(tileFormats, format) {
+				wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "format", "Unsupported tile format")
+				return
+			}
+		}
+		s.serveWMTSTile(w, r,
+			params["LAYER"],
+			params["STYLE"],
+			params["TILEMATRIXSET"],
+			params["TILEMATRIX"],
+			params["TILEROW"],
+			params["TILECOL"],
+			format)
+
+	case "":
+		wmtsError(w, http.StatusBadRequest, "MissingParameterValue", "request", "Missing REQUEST parameter")
+
+	default:
+		wmtsError(w, http.StatusBadRequest, "OperationNotSupported", "request", "Unsupported operation")
+	}
+}
+
+// serveWMTSTile maps a WMTS tile request onto the XYZ tile of the map layer.
+func (s *ServerContext) serveWMTSTile(w http.ResponseWriter, r *http.Request, layer, style, matrixSet, matrix, row, col, format string) {
+	start := time.Now()
+
+	mapName, ok := s.resolveMap(w, r, layer)
+	if !ok {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "layer", "Unknown layer")
+		return
+	}
+
+	if style == "" || strings.EqualFold(style, "default") {
+		style = s.defaultStyle(mapName)
+	}
+	if !s.hasLayer(mapName, style) {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "style", "Unknown style")
+		return
+	}
+
+	if matrixSet != wmtsTileMatrixSet {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "tilematrixset", "Unknown tile matrix set")
+		return
+	}
+
+	z, errZ := strconv.Atoi(matrix)
+	y, errY := strconv.Atoi(row)
+	x, errX := strconv.Atoi(col)
+	if errZ != nil || errY != nil || errX != nil || z < 0 || z > 30 {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "tilematrix", "Invalid tile coordinates")
+		return
+	}
+	if n := 1 << z; x < 0 || y < 0 || x >= n || y >= n {
+		wmtsError(w, http.StatusBadRequest, "TileOutOfRange", "tilerow", "Tile is outside of the tile matrix")
+		return
+	}
+
+	result := s.serveLayerTile(w, r, mapName, style, z, x, y, format, false, s.tileCacheControl)
+	observeTile(mapName, style, z, result, start)
+}
+
+// serveWMTSCapabilities writes the GetCapabilities document listing every map as a layer.
+func (s *ServerContext) serveWMTSCapabilities(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r) + "/wmts"
+
+	caps := wmtsCapabilities{
+		Xmlns:      "http://www.opengis.net/wmts/1.0",
+		XmlnsOws:   "http://www.opengis.net/ows/1.1",
+		XmlnsXlink: "http://www.w3.org/1999/xlink",
+		Version:    "1.0.0",
+		ServiceIdent: wmtsServiceIdent{
+			Title:              "DZMap",
+			ServiceType:        "OGC WMTS",
+			ServiceTypeVersion: "1.0.0",
+		},
+		ServiceMetadataURL: wmtsHref{Href: base + "/1.0.0/WMTSCapabilities.xml"},
+	}
+
+	for _, op := range []string{"GetCapabilities", "GetTile"} {
+		caps.OperationsMeta.Operations = append(caps.OperationsMeta.Operations, wmtsOperation{
+			Name: op,
+			Get: wmtsGet{
+				Href:       base + "?",
+				Constraint: wmtsConstraint{Name: "GetEncoding", Values: "KVP"},
+			},
+		})
+	}
+
+	maxZoom := 0
+	for _, world := range s.visibleMaps(r) {
+		markPrivate(w, &world)
+
+		// matrix limits cover the layer with the most zoom levels
+		zoomLimit := world.ZoomLimit
+		for _, l := range world.Layers {
+			zoomLimit = max(zoomLimit, l.ZoomLimit)
+		}
+		maxZoom = max(maxZoom, zoomLimit)
+
+		layer := wmtsLayer{
+			Title:      world.Name,
+			Abstract:   "DayZ " + world.Name + " map",
+			Identifier: world.Name,
+			Link:       wmtsTileMatrixLink{TileMatrixSet: wmtsTileMatrixSet},
+		}
+
+		bounds := mapBounds(&world)
+		layer.BoundingBox = wmtsBBox{
+			LowerCorner: fmt.Sprintf("%.8f %.8f", bounds[0], bounds[1]),
+			UpperCorner: fmt.Sprintf("%.8f %.8f", bounds[2], bounds[3]),
+		}
+
+		for _, format := range tileFormats {
+			ext := format
+			if format == render.FormatJPEG {
+				ext = "jpg"
+			}
+			layer.Formats = append(layer.Formats, render.ContentType(format))
+			layer.ResourceURL = append(layer.ResourceURL, wmtsResourceURL{
+				Format:       render.ContentType(format),
+				ResourceType: "tile",
+				Template:     base + "/1.0.0/" + world.Name + "/{Style}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}." + ext + accessQuery(r, &world),
+			})
+		}
+
+		defaultStyle := s.defaultStyle(world.Name)
+		for _, l := range world.Layers {
+			layer.Styles = append(layer.Styles, wmtsStyle{
+				IsDefault:  l.Name == defaultStyle,
+				Title:      titleCase(l.Name),
+				Identifier: l.Name,
+			})
+		}
+
+		for z := 0; z <= zoomLimit; z++ {
+			n := 1 << z
+			layer.Link.Limits = append(layer.Link.Limits, wmtsMatrixLimits{
+				TileMatrix: strconv.Itoa(z),
+				MaxTileRow: n - 1,
+				MaxTileCol: n - 1,
+			})
+		}
+
+		caps.Contents.Layers = append(caps.Contents.Layers, layer)
+	}
+
+	caps.Contents.TileMatrixSet = wmtsTileMatrixSetT{
+		Identifier:   wmtsTileMatrixSet,
+		SupportedCRS: "urn:ogc:def:crs:EPSG::3857",
+		WellKnown:    "urn:ogc:def:wkss:OGC:1.0:GoogleMapsCompatible",
+	}
+	corner := fmt.Sprintf("%.7f %.7f", -webMercatorOrigin, webMercatorOrigin)
+	for z := 0; z <= maxZoom; z++ {
+		n := 1 << z
+		caps.Contents.TileMatrixSet.Matrices = append(caps.Contents.TileMatrixSet.Matrices, wmtsTileMatrix{
+			Identifier:       strconv.Itoa(z),
+			ScaleDenominator: wmtsScaleDenominator0 / float64(n),
+			TopLeftCorner:    corner,
+			TileWidth:        256,
+			TileHeight:       256,
+			MatrixWidth:      n,
+			MatrixHeight:     n,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	setCacheControl(w, "public, no-cache")
+	_, _ = w.Write([]byte(xml.Header))
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	_ = enc.Encode(caps)
+}
+
+// defaultStyle returns the preferred available layer of a map.
+func (s *ServerContext) defaultStyle(mapName string) string {
+	if world := s.findMap(mapName); world != nil {
+		return world.DefaultLayer()
+	}
+
+	return ""
+}
+
+// wmtsError writes an OWS exception report.
+func wmtsError(w http.ResponseWriter, status int, code, locator, text string) {
+	report := wmtsException{
+		XmlnsOws: "http://www.opengis.net/ows/1.1",
+		Version:  "1.1.0",
+	}
+	report.Exception.Code = code
+	report.Exception.Locator = locator
+	report.Exception.Text = text
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(report)
+}
+
+// titleCase upper cases the first letter of name.
+func titleCase(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	if r == utf8.RuneError {
+		return name
+	}
+
+	return string(unicode.ToUpper(r)) + name[size:]
+}